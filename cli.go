@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/tade3910/chirpy/db"
+)
+
+const usage = `usage: chirpy [--debug] [command]
+
+Starts the server when no command is given.
+
+commands:
+  bootstrap-admin <email>  make an existing user the first admin`
+
+// Runs a command given on the command line instead of starting the server
+func runCommand(args []string, database *db.Db) error {
+	switch args[0] {
+	case "bootstrap-admin":
+		if len(args) != 2 {
+			return fmt.Errorf(usage)
+		}
+		return bootstrapAdmin(database, args[1])
+	default:
+		return fmt.Errorf(usage)
+	}
+}
+
+// Only works while there are no admins, later admins are appointed through /admin/users/{id}/role
+func bootstrapAdmin(database *db.Db, email string) error {
+	_, err := database.Apply(func(readDatabase *db.Database) (int, error) {
+		if readDatabase.HasAdmin() {
+			return 409, fmt.Errorf("an admin already exists")
+		}
+		user, exists := readDatabase.Users[email]
+		if !exists {
+			return 404, fmt.Errorf("no user with email %s, sign up first", email)
+		}
+		user.Role = db.RoleAdmin
+		readDatabase.PutUser(user)
+		return 200, nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is now an admin\n", email)
+	return nil
+}
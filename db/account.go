@@ -0,0 +1,108 @@
+package db
+
+import (
+	"time"
+
+	"github.com/tade3910/chirpy/util"
+)
+
+// What happens to a deleted user's chirps
+type ChirpDeletionPolicy string
+
+const (
+	DeleteChirps    ChirpDeletionPolicy = "delete"
+	AnonymizeChirps ChirpDeletionPolicy = "anonymize"
+)
+
+// Author of chirps whose user deleted their account
+const DeletedUserId = -1
+
+// Left behind by a deleted account, keyed by the SHA-256 of its email so the
+// email itself isn't kept
+type Tombstone struct {
+	DeletedAt time.Time
+}
+
+func tombstoneKey(email string) string {
+	return util.HashToken(email)
+}
+
+// Whether the email belonged to an account deleted less than cooldown ago
+func (database *Database) IsEmailCoolingDown(email string, cooldown time.Duration, now time.Time) bool {
+	tombstone, exists := database.Tombstones[tombstoneKey(email)]
+	return exists && tombstone.DeletedAt.Add(cooldown).After(now)
+}
+
+// Removes the user and everything pointing at them, leaving a tombstone for their email
+func (database *Database) DeleteUser(user *User, policy ChirpDeletionPolicy) {
+	for id, chirp := range database.Chirps {
+		if chirp.AuthorId != user.Id {
+			continue
+		}
+		if policy == AnonymizeChirps {
+			chirp.AuthorId = DeletedUserId
+			database.Chirps[id] = chirp
+		} else {
+			delete(database.Chirps, id)
+		}
+	}
+	database.RevokeUserSessions(user.Id)
+	for token, rotated := range database.RotatedSessions {
+		if rotated.UserId == user.Id {
+			delete(database.RotatedSessions, token)
+		}
+	}
+	for hash, token := range database.EmailTokens {
+		if token.UserId == user.Id {
+			delete(database.EmailTokens, hash)
+		}
+	}
+	for hash, challenge := range database.LoginChallenges {
+		if challenge.UserId == user.Id {
+			delete(database.LoginChallenges, hash)
+		}
+	}
+	for hash, key := range database.ApiKeys {
+		if key.UserId == user.Id {
+			delete(database.ApiKeys, hash)
+		}
+	}
+	for id, client := range database.OAuthClients {
+		if client.OwnerId == user.Id {
+			database.DeleteOAuthClient(id)
+		}
+	}
+	for hash, code := range database.AuthorizationCodes {
+		if code.UserId == user.Id {
+			delete(database.AuthorizationCodes, hash)
+		}
+	}
+	for id, export := range database.Exports {
+		if export.UserId == user.Id {
+			delete(database.Exports, id)
+		}
+	}
+	database.removeUserRelations(user.Id)
+	delete(database.Handles, user.Handle)
+	delete(database.Users, user.Email)
+	delete(database.IDUsersMap, user.Id)
+	database.Tombstones[tombstoneKey(user.Email)] = Tombstone{
+		DeletedAt: time.Now().UTC(),
+	}
+}
+
+// Moves the user to a new email. The new address has to be verified again and
+// links already mailed to the old one stop working. The caller puts the user.
+func (database *Database) ChangeEmail(user *User, email string) {
+	if email == user.Email {
+		return
+	}
+	delete(database.Users, user.Email)
+	user.Email = email
+	user.Is_verified = false
+	for hash, token := range database.EmailTokens {
+		if token.UserId == user.Id {
+			delete(database.EmailTokens, hash)
+		}
+	}
+}
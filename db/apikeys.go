@@ -0,0 +1,75 @@
+package db
+
+import (
+	"slices"
+	"time"
+
+	"github.com/tade3910/chirpy/util"
+)
+
+// A long lived credential a user creates for a bot or integration
+type ApiKey struct {
+	Id         string
+	UserId     int
+	Name       string
+	Prefix     string
+	Scopes     []Scope
+	CreatedAt  time.Time
+	Expires    *time.Time `json:",omitempty"`
+	LastUsedAt *time.Time `json:",omitempty"`
+}
+
+func (key ApiKey) IsExpired(now time.Time) bool {
+	return key.Expires != nil && key.Expires.Before(now)
+}
+
+func (key ApiKey) HasScope(scope Scope) bool {
+	return slices.Contains(key.Scopes, scope)
+}
+
+// Stores the key under the hash of its secret
+func (database *Database) PutApiKey(secret string, key ApiKey) {
+	key.Prefix = tokenPrefix(secret)
+	database.ApiKeys[util.HashToken(secret)] = key
+}
+
+func (database *Database) GetApiKey(secret string) (ApiKey, bool) {
+	key, exists := database.ApiKeys[util.HashToken(secret)]
+	return key, exists
+}
+
+// Returns the user's keys, newest first
+func (database *Database) GetUserApiKeys(userId int) []ApiKey {
+	keys := []ApiKey{}
+	for _, key := range database.ApiKeys {
+		if key.UserId == userId {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a ApiKey, b ApiKey) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return keys
+}
+
+func (database *Database) RevokeApiKey(userId int, id string) bool {
+	for hash, key := range database.ApiKeys {
+		if key.Id == id && key.UserId == userId {
+			delete(database.ApiKeys, hash)
+			return true
+		}
+	}
+	return false
+}
+
+// Records use of the key, at most once a minute so busy bots don't rewrite the database constantly
+func (database *Database) TouchApiKey(secret string, now time.Time) bool {
+	hash := util.HashToken(secret)
+	key, exists := database.ApiKeys[hash]
+	if !exists || (key.LastUsedAt != nil && now.Sub(*key.LastUsedAt) < time.Minute) {
+		return false
+	}
+	key.LastUsedAt = &now
+	database.ApiKeys[hash] = key
+	return true
+}
@@ -9,70 +9,210 @@ import (
 )
 
 type Database struct {
-	Chirps     map[int]Chirp
-	Users      map[string]*User
-	IDUsersMap map[int]*User
-	Sessions   map[string]Session
+	// Ids are never handed out twice, even once the chirp or user is gone,
+	// since reports, the audit log and tombstones keep referring to them
+	NextChirpId int
+	NextUserId  int
+	Chirps      map[int]Chirp
+	Users       map[string]*User
+	IDUsersMap  map[int]*User
+	Handles     map[string]int
+	Sessions    map[string]Session
+	EmailTokens map[string]EmailToken
+	Tombstones  map[string]Tombstone
+	Exports     map[string]Export
+	// Keyed by the user who blocked or muted, then the user they blocked or muted
+	Blocks   map[int]map[int]time.Time
+	Mutes    map[int]map[int]time.Time
+	Reports  map[int]Report
+	AuditLog []AuditEntry
+	// Keyed by the SHA-256 of the refresh token that was rotated
+	RotatedSessions map[string]RotatedSession
+	SecurityEvents  []SecurityEvent
+	// Keyed by the SHA-256 of the challenge token
+	LoginChallenges map[string]LoginChallenge
+	// Keyed by the SHA-256 of the key's secret
+	ApiKeys map[string]ApiKey
+	// Keyed by client id
+	OAuthClients map[string]OAuthClient
+	// Keyed by the SHA-256 of the code
+	AuthorizationCodes map[string]AuthorizationCode
+}
+
+type tokenPurpose string
+
+const (
+	VerifyEmail   tokenPurpose = "verify"
+	ResetPassword tokenPurpose = "reset"
+)
+
+// Tokens mailed to users, keyed by the SHA-256 of the token so the database
+// never holds a usable token
+type EmailToken struct {
+	UserId  int
+	Purpose tokenPurpose
+	Expires time.Time
 }
 
 type Session struct {
 	User    *User
 	Expires time.Time
+	// Shared by every session rotated from the same login
+	FamilyId string
+	// The first few characters of the refresh token so users can tell sessions apart
+	TokenPrefix string
+	// When the session was first logged in and where it was last refreshed from
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	UserAgent  string
+	Ip         string
+	// Set when the login asked for a restricted token, empty for a full login
+	Scopes []Scope `json:",omitempty"`
+	// The OAuth client the session was issued to, empty for logins to chirpy itself
+	ClientId string `json:",omitempty"`
+	// Expires slides forward on every refresh but never past MaxExpires
+	MaxExpires time.Time
+	RememberMe bool
 }
 
 type User struct {
-	Password []byte
+	Password      []byte
+	Subscriptions []SubscriptionEvent
+	Suspension    *Suspension `json:",omitempty"`
+	TwoFactor     *TwoFactor  `json:",omitempty"`
 	PlainUser
 }
 
+// Changes to a user's Chirpy Red subscription as reported by Polka
+type SubscriptionEvent struct {
+	Event string
+	At    time.Time
+}
+
 type PlainUser struct {
 	Id            int
 	Email         string
 	Is_chirpy_red bool
+	Is_verified   bool
+	Role          Role `json:",omitempty"`
+	Profile
+}
+
+type Profile struct {
+	Handle      string
+	DisplayName string
+	Bio         string
+	AvatarUrl   string
+}
+
+// What other users are allowed to see, notably without the email
+type PublicUser struct {
+	Id            int
+	Is_chirpy_red bool
+	Profile
+}
+
+func (user *User) ToPublic() PublicUser {
+	return PublicUser{
+		Id:            user.Id,
+		Is_chirpy_red: user.Is_chirpy_red,
+		Profile:       user.Profile,
+	}
+}
+
+// Keeps both user indexes pointing at the same user
+func (database *Database) PutUser(user *User) {
+	database.Users[user.Email] = user
+	database.IDUsersMap[user.Id] = user
 }
 
 type Chirp struct {
-	Id       int
-	Body     string
-	AuthorId int
+	Id        int
+	Body      string
+	AuthorId  int
+	Expires   *time.Time `json:",omitempty"`
+	DeletedAt *time.Time `json:",omitempty"`
+	// Hidden by a moderator
+	Hidden bool `json:",omitempty"`
+}
+
+// Chirps without an expiry never expire
+func (chirp Chirp) IsExpired(now time.Time) bool {
+	return chirp.Expires != nil && !chirp.Expires.After(now)
+}
+
+// Whether the chirp should show up when reading chirps
+func (chirp Chirp) IsVisible(now time.Time) bool {
+	return chirp.DeletedAt == nil && !chirp.IsExpired(now)
+}
+
+// Whether the chirp was deleted but can still be restored
+func (chirp Chirp) IsInTrash(now time.Time, retention time.Duration) bool {
+	return chirp.DeletedAt != nil && chirp.DeletedAt.Add(retention).After(now)
 }
 
 type Db struct {
-	mu         sync.Mutex
-	path       string
-	nextId     int
-	nextUserId int
+	mu             sync.Mutex
+	path           string
+	trashRetention time.Duration
+	purgedChirps   int
+	purgedTrash    int
+	purgedSessions int
+	// Held for the whole of an Update and by every write, so no write lands in the middle of an Update
+	writeMu sync.Mutex
 }
 
-func GetNewSession(user *User) Session {
-	return Session{
-		User:    user,
-		Expires: time.Now().UTC().Add(time.Duration(60*24) * time.Hour),
+// Stores a new token, invalidating older tokens with the same user and purpose
+func (database *Database) PutEmailToken(tokenHash string, token EmailToken) {
+	for hash, existing := range database.EmailTokens {
+		if existing.UserId == token.UserId && existing.Purpose == token.Purpose {
+			delete(database.EmailTokens, hash)
+		}
 	}
+	database.EmailTokens[tokenHash] = token
 }
 
-func (database *Db) GetNextId() int {
-	database.mu.Lock()
-	defer database.mu.Unlock()
-	return database.nextId
+// Tokens are single use so a found token is always removed
+func (database *Database) ConsumeEmailToken(tokenHash string, purpose tokenPurpose) (EmailToken, bool) {
+	token, exists := database.EmailTokens[tokenHash]
+	if !exists || token.Purpose != purpose {
+		return EmailToken{}, false
+	}
+	delete(database.EmailTokens, tokenHash)
+	if token.Expires.Before(time.Now().UTC()) {
+		return EmailToken{}, false
+	}
+	return token, true
 }
 
-func (database *Db) GetNextUserId() int {
-	database.mu.Lock()
-	defer database.mu.Unlock()
-	return database.nextUserId
+// Removes every session belonging to the user, returning how many were removed
+func (database *Database) RevokeUserSessions(userId int) int {
+	revoked := 0
+	for token, session := range database.Sessions {
+		if session.User.Id == userId {
+			delete(database.Sessions, token)
+			revoked++
+		}
+	}
+	return revoked
 }
 
-func (database *Db) addId() {
-	database.mu.Lock()
-	defer database.mu.Unlock()
-	database.nextId++
+func (database *Database) NewChirpId() int {
+	id := database.NextChirpId
+	database.NextChirpId++
+	return id
+}
+
+func (database *Database) NewUserId() int {
+	id := database.NextUserId
+	database.NextUserId++
+	return id
 }
 
-func (database *Db) addUserId() {
+func (database *Db) GetTrashRetention() time.Duration {
 	database.mu.Lock()
 	defer database.mu.Unlock()
-	database.nextUserId++
+	return database.trashRetention
 }
 
 // Returns current database, handles empty json
@@ -85,10 +225,26 @@ func (database *Db) GetDatabase() (*Database, bool) {
 		return nil, false
 	}
 	currentDatabase := &Database{
-		Chirps:     map[int]Chirp{},
-		Users:      map[string]*User{},
-		IDUsersMap: map[int]*User{},
-		Sessions:   map[string]Session{},
+		Chirps:      map[int]Chirp{},
+		Users:       map[string]*User{},
+		IDUsersMap:  map[int]*User{},
+		Handles:     map[string]int{},
+		Sessions:    map[string]Session{},
+		EmailTokens: map[string]EmailToken{},
+		Tombstones:  map[string]Tombstone{},
+		Exports:     map[string]Export{},
+		Blocks:      map[int]map[int]time.Time{},
+		Mutes:       map[int]map[int]time.Time{},
+		Reports:     map[int]Report{},
+		AuditLog:    []AuditEntry{},
+
+		RotatedSessions: map[string]RotatedSession{},
+		SecurityEvents:  []SecurityEvent{},
+		LoginChallenges: map[string]LoginChallenge{},
+		ApiKeys:         map[string]ApiKey{},
+
+		OAuthClients:       map[string]OAuthClient{},
+		AuthorizationCodes: map[string]AuthorizationCode{},
 	}
 	if len(fileContent) == 0 {
 		return currentDatabase, true
@@ -101,29 +257,33 @@ func (database *Db) GetDatabase() (*Database, bool) {
 	return currentDatabase, true
 }
 
-type databaseName string
-
-const (
-	ChirpDatabase databaseName = "chirp"
-	UserDatabase  databaseName = "user"
-	NoDatabase    databaseName = ""
-)
+// Reads, changes and writes the database without any other write landing in
+// between, so no change is lost to one made from an older read. change returns
+// whether it changed anything that needs writing, and must not call Update itself.
+func (db *Db) Update(change func(*Database) bool) bool {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	database, ok := db.GetDatabase()
+	if !ok {
+		return false
+	}
+	if !change(database) {
+		return true
+	}
+	return db.writeToJson(database)
+}
 
-func (db *Db) UpdateDatabase(database *Database, update databaseName) bool {
-	ok := db.writeToJson(database)
-	if ok {
-		switch update {
-		case ChirpDatabase:
-			db.addId()
-		case UserDatabase:
-			db.addUserId()
-		case NoDatabase:
-			break
-		default:
-			return false
-		}
+// Update for request handlers. change returns the status code and error to
+// respond with, nothing is written when it returns an error.
+func (db *Db) Apply(change func(*Database) (int, error)) (int, error) {
+	statusCode, err := 200, error(nil)
+	if !db.Update(func(database *Database) bool {
+		statusCode, err = change(database)
+		return err == nil
+	}) {
+		return 500, fmt.Errorf("could not update database")
 	}
-	return ok
+	return statusCode, err
 }
 
 func (db *Db) writeToJson(database *Database) bool {
@@ -147,16 +307,42 @@ func (db *Db) writeToJson(database *Database) bool {
 	return true
 }
 
-func GetDb() (*Db, bool) {
+// Files from before the counters were stored carry on numbering after what's
+// already there. Returns whether anything changed.
+func (database *Database) migrateIds() bool {
+	nextChirpId, nextUserId := database.NextChirpId, database.NextUserId
+	for id := range database.Chirps {
+		database.NextChirpId = max(database.NextChirpId, id+1)
+	}
+	for id := range database.IDUsersMap {
+		database.NextUserId = max(database.NextUserId, id+1)
+	}
+	return database.NextChirpId != nextChirpId || database.NextUserId != nextUserId
+}
+
+// Opens database.json, keeping what is already there unless reset is set
+func GetDb(trashRetention time.Duration, reset bool) (*Db, bool) {
 	path := "database.json"
-	file, err := os.Create(path)
+	flags := os.O_RDWR | os.O_CREATE
+	if reset {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0644)
 	if err != nil {
 		fmt.Println("Error creating file:", err)
 		return nil, false
 	}
 	defer file.Close()
 	newDb := &Db{
-		path: "database.json",
+		path:           "database.json",
+		trashRetention: trashRetention,
+	}
+	if !newDb.Update(func(existing *Database) bool {
+		idsMigrated := existing.migrateIds()
+		sessionsMigrated := existing.migrateSessions()
+		return idsMigrated || sessionsMigrated
+	}) {
+		return nil, false
 	}
 	return newDb, true
 }
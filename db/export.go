@@ -0,0 +1,34 @@
+package db
+
+import "time"
+
+type ExportStatus string
+
+const (
+	ExportPending ExportStatus = "pending"
+	ExportRunning ExportStatus = "running"
+	ExportReady   ExportStatus = "ready"
+	ExportFailed  ExportStatus = "failed"
+)
+
+// A personal data archive requested by a user
+type Export struct {
+	Id        string
+	UserId    int
+	Status    ExportStatus
+	CreatedAt time.Time
+	// Set once ready, the archive and its download links stop working after this
+	Expires *time.Time `json:",omitempty"`
+	// SHA-256 of the current download token
+	DownloadTokenHash string
+}
+
+// Returns the user's export that is still being built, if any
+func (database *Database) GetUnfinishedExport(userId int) (Export, bool) {
+	for _, export := range database.Exports {
+		if export.UserId == userId && (export.Status == ExportPending || export.Status == ExportRunning) {
+			return export, true
+		}
+	}
+	return Export{}, false
+}
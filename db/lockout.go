@@ -0,0 +1,126 @@
+package db
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failed logins counted against one email or one IP address
+type LoginFailures struct {
+	Count       int
+	LastFailure time.Time
+	LockedUntil *time.Time `json:",omitempty"`
+}
+
+// How many failures are allowed before logins are refused for a while. Each
+// failure past the threshold doubles the lockout up to MaxLockout.
+type LockoutPolicy struct {
+	AccountThreshold int
+	IpThreshold      int
+	Lockout          time.Duration
+	MaxLockout       time.Duration
+	// Failures are forgotten once this long passes without another one
+	Window time.Duration
+}
+
+const (
+	LoginLockout   = "login_lockout"
+	LockoutCleared = "lockout_cleared"
+)
+
+// Emails are compared case insensitively so changing case doesn't reset the count
+func AccountLockoutKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func IpLockoutKey(ip string) string {
+	return "ip:" + ip
+}
+
+func (failures LoginFailures) IsLocked(now time.Time) bool {
+	return failures.LockedUntil != nil && failures.LockedUntil.After(now)
+}
+
+// Counts failed logins in memory rather than in the database file. Requests
+// write back the whole database they read, which would undo failures counted
+// by parallel requests in the meantime. Counts start over on restart.
+type Lockouts struct {
+	mu       sync.Mutex
+	policy   LockoutPolicy
+	failures map[string]LoginFailures
+}
+
+func GetLockouts(policy LockoutPolicy) *Lockouts {
+	return &Lockouts{
+		policy:   policy,
+		failures: map[string]LoginFailures{},
+	}
+}
+
+func (lockouts *Lockouts) Policy() LockoutPolicy {
+	return lockouts.policy
+}
+
+// Returns when the key can be tried again if it is locked out
+func (lockouts *Lockouts) GetLockout(key string, now time.Time) (time.Time, bool) {
+	lockouts.mu.Lock()
+	defer lockouts.mu.Unlock()
+	failures, exists := lockouts.failures[key]
+	if !exists || !failures.IsLocked(now) {
+		return time.Time{}, false
+	}
+	return *failures.LockedUntil, true
+}
+
+// Counts a failure against the key, returning whether it locked the key
+func (lockouts *Lockouts) RecordFailure(key string, threshold int, now time.Time) bool {
+	lockouts.mu.Lock()
+	defer lockouts.mu.Unlock()
+	lockouts.forget(now)
+	policy := lockouts.policy
+	failures := lockouts.failures[key]
+	failures.Count++
+	failures.LastFailure = now
+	locked := false
+	if threshold > 0 && failures.Count >= threshold {
+		lockout := policy.Lockout
+		for i := threshold; i < failures.Count && lockout < policy.MaxLockout; i++ {
+			lockout *= 2
+		}
+		lockout = min(lockout, policy.MaxLockout)
+		until := now.Add(lockout)
+		failures.LockedUntil = &until
+		locked = true
+	}
+	lockouts.failures[key] = failures
+	return locked
+}
+
+func (lockouts *Lockouts) Clear(key string) bool {
+	lockouts.mu.Lock()
+	defer lockouts.mu.Unlock()
+	_, exists := lockouts.failures[key]
+	delete(lockouts.failures, key)
+	return exists
+}
+
+// Returns a copy of the failures still within the window, keyed like the lockouts
+func (lockouts *Lockouts) GetFailures(now time.Time) map[string]LoginFailures {
+	lockouts.mu.Lock()
+	defer lockouts.mu.Unlock()
+	lockouts.forget(now)
+	failures := map[string]LoginFailures{}
+	for key, failure := range lockouts.failures {
+		failures[key] = failure
+	}
+	return failures
+}
+
+func (lockouts *Lockouts) forget(now time.Time) {
+	for key, failures := range lockouts.failures {
+		if !failures.IsLocked(now) && failures.LastFailure.Add(lockouts.policy.Window).Before(now) {
+			delete(lockouts.failures, key)
+		}
+	}
+}
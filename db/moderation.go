@@ -0,0 +1,99 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+type Target string
+
+const (
+	ChirpTarget Target = "chirp"
+	UserTarget  Target = "user"
+)
+
+type ReportReason string
+
+var ReportReasons = []ReportReason{"spam", "harassment", "hate", "violence", "sexual", "self_harm", "misinformation", "impersonation", "other"}
+
+func (reason ReportReason) IsValid() bool {
+	for _, valid := range ReportReasons {
+		if reason == valid {
+			return true
+		}
+	}
+	return false
+}
+
+type ReportStatus string
+
+const (
+	ReportOpen     ReportStatus = "open"
+	ReportClaimed  ReportStatus = "claimed"
+	ReportResolved ReportStatus = "resolved"
+)
+
+type Report struct {
+	Id         int
+	ReporterId int
+	TargetType Target
+	TargetId   int
+	Reason     ReportReason
+	Details    string `json:",omitempty"`
+	Status     ReportStatus
+	CreatedAt  time.Time
+	ClaimedBy  *int       `json:",omitempty"`
+	ResolvedAt *time.Time `json:",omitempty"`
+	Resolution string     `json:",omitempty"`
+}
+
+// A record of something a moderator did, kept forever
+type AuditEntry struct {
+	Id          int
+	ModeratorId int
+	Action      string
+	TargetType  Target
+	TargetId    int
+	ReportId    *int   `json:",omitempty"`
+	Details     string `json:",omitempty"`
+	At          time.Time
+}
+
+func (database *Database) AddReport(report Report) Report {
+	report.Id = len(database.Reports)
+	for id := range database.Reports {
+		report.Id = max(report.Id, id+1)
+	}
+	database.Reports[report.Id] = report
+	return report
+}
+
+// Whether the reporter already has an unresolved report about the target
+func (database *Database) HasOpenReport(reporterId int, targetType Target, targetId int) bool {
+	for _, report := range database.Reports {
+		if report.ReporterId == reporterId && report.TargetType == targetType && report.TargetId == targetId && report.Status != ReportResolved {
+			return true
+		}
+	}
+	return false
+}
+
+// Oldest first, with an empty status meaning every unresolved report
+func (database *Database) GetReports(status ReportStatus) []Report {
+	reports := []Report{}
+	for _, report := range database.Reports {
+		if report.Status == status || (status == "" && report.Status != ReportResolved) {
+			reports = append(reports, report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Id < reports[j].Id
+	})
+	return reports
+}
+
+func (database *Database) Audit(entry AuditEntry) {
+	entry.Id = len(database.AuditLog)
+	entry.At = time.Now().UTC()
+	database.AuditLog = append(database.AuditLog, entry)
+}
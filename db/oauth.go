@@ -0,0 +1,112 @@
+package db
+
+import (
+	"crypto/subtle"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/tade3910/chirpy/util"
+)
+
+// A third party app registered by a user so other users can let it act for them
+type OAuthClient struct {
+	Id           string
+	OwnerId      int
+	Name         string
+	RedirectUris []string
+	// What users can grant the client, its tokens are always limited to some of these
+	Scopes []Scope
+	// Empty for public clients such as mobile apps, which only have PKCE to prove who they are
+	SecretHash string `json:",omitempty"`
+	CreatedAt  time.Time
+}
+
+func (client OAuthClient) IsConfidential() bool {
+	return client.SecretHash != ""
+}
+
+func (client OAuthClient) CheckSecret(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(util.HashToken(secret))) == 1
+}
+
+// Redirect uris have to match one registered exactly
+func (client OAuthClient) HasRedirectUri(uri string) bool {
+	return slices.Contains(client.RedirectUris, uri)
+}
+
+// Handed to the client's redirect uri after the user consents, exchanged once for tokens
+type AuthorizationCode struct {
+	ClientId    string
+	UserId      int
+	RedirectUri string
+	Scopes      []Scope
+	// The S256 PKCE challenge the code verifier has to hash to
+	CodeChallenge string
+	Expires       time.Time
+	// Set once the code is exchanged. Kept until it expires so a second exchange can revoke the tokens.
+	FamilyId string `json:",omitempty"`
+}
+
+const AuthorizationCodeReuse = "authorization_code_reuse"
+
+func (database *Database) PutOAuthClient(client OAuthClient) {
+	database.OAuthClients[client.Id] = client
+}
+
+func (database *Database) GetOAuthClient(id string) (OAuthClient, bool) {
+	client, exists := database.OAuthClients[id]
+	return client, exists
+}
+
+// Returns the clients the user registered, newest first
+func (database *Database) GetUserOAuthClients(ownerId int) []OAuthClient {
+	clients := []OAuthClient{}
+	for _, client := range database.OAuthClients {
+		if client.OwnerId == ownerId {
+			clients = append(clients, client)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].CreatedAt.After(clients[j].CreatedAt)
+	})
+	return clients
+}
+
+// Removes the client along with its codes and every session issued to it.
+// Returns the families of those sessions so their access tokens can be revoked.
+func (database *Database) DeleteOAuthClient(id string) []string {
+	familyIds := []string{}
+	for hash, session := range database.Sessions {
+		if session.ClientId == id {
+			delete(database.Sessions, hash)
+			familyIds = append(familyIds, session.FamilyId)
+		}
+	}
+	for hash, code := range database.AuthorizationCodes {
+		if code.ClientId == id {
+			delete(database.AuthorizationCodes, hash)
+		}
+	}
+	delete(database.OAuthClients, id)
+	return familyIds
+}
+
+func (database *Database) PutAuthorizationCode(code string, authorization AuthorizationCode) {
+	database.AuthorizationCodes[util.HashToken(code)] = authorization
+}
+
+func (database *Database) GetAuthorizationCode(code string) (AuthorizationCode, bool) {
+	authorization, exists := database.AuthorizationCodes[util.HashToken(code)]
+	return authorization, exists
+}
+
+// Returns the session issued for the login the family started from
+func (database *Database) GetFamilySession(familyId string) (Session, bool) {
+	for _, session := range database.Sessions {
+		if session.FamilyId == familyId {
+			return session, true
+		}
+	}
+	return Session{}, false
+}
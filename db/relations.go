@@ -0,0 +1,85 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// A user someone blocked or muted and when they did it
+type Relation struct {
+	UserId int
+	Since  time.Time
+}
+
+func addRelation(relations map[int]map[int]time.Time, userId int, otherId int) {
+	if relations[userId] == nil {
+		relations[userId] = map[int]time.Time{}
+	}
+	if _, exists := relations[userId][otherId]; !exists {
+		relations[userId][otherId] = time.Now().UTC()
+	}
+}
+
+func removeRelation(relations map[int]map[int]time.Time, userId int, otherId int) {
+	delete(relations[userId], otherId)
+	if len(relations[userId]) == 0 {
+		delete(relations, userId)
+	}
+}
+
+func listRelations(relations map[int]map[int]time.Time, userId int) []Relation {
+	list := []Relation{}
+	for otherId, since := range relations[userId] {
+		list = append(list, Relation{UserId: otherId, Since: since})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].UserId < list[j].UserId
+	})
+	return list
+}
+
+func (database *Database) Block(userId int, blockedId int) {
+	addRelation(database.Blocks, userId, blockedId)
+}
+
+func (database *Database) Unblock(userId int, blockedId int) {
+	removeRelation(database.Blocks, userId, blockedId)
+}
+
+func (database *Database) Mute(userId int, mutedId int) {
+	addRelation(database.Mutes, userId, mutedId)
+}
+
+func (database *Database) Unmute(userId int, mutedId int) {
+	removeRelation(database.Mutes, userId, mutedId)
+}
+
+func (database *Database) GetBlocks(userId int) []Relation {
+	return listRelations(database.Blocks, userId)
+}
+
+func (database *Database) GetMutes(userId int) []Relation {
+	return listRelations(database.Mutes, userId)
+}
+
+// Blocks work both ways, neither user can interact with the other
+func (database *Database) IsBlocked(userId int, otherId int) bool {
+	_, blocked := database.Blocks[userId][otherId]
+	_, blockedBy := database.Blocks[otherId][userId]
+	return blocked || blockedBy
+}
+
+// Whether chirps by authorId should be left out of what viewerId reads
+func (database *Database) IsHiddenFrom(viewerId int, authorId int) bool {
+	_, muted := database.Mutes[viewerId][authorId]
+	return muted || database.IsBlocked(viewerId, authorId)
+}
+
+func (database *Database) removeUserRelations(userId int) {
+	for _, relations := range []map[int]map[int]time.Time{database.Blocks, database.Mutes} {
+		delete(relations, userId)
+		for otherId := range relations {
+			removeRelation(relations, otherId, userId)
+		}
+	}
+}
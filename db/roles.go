@@ -0,0 +1,47 @@
+package db
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Higher roles can do everything lower roles can
+var roleRanks = map[Role]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+func (role Role) IsValid() bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// Users created before roles existed have no role and count as RoleUser
+func (role Role) Includes(required Role) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+// Moderation actions only go down the ranks
+func (role Role) Outranks(other Role) bool {
+	return roleRanks[role] > roleRanks[other]
+}
+
+func (user *User) GetRole() Role {
+	if user.Role == "" {
+		return RoleUser
+	}
+	return user.Role
+}
+
+func (database *Database) HasAdmin() bool {
+	for _, user := range database.IDUsersMap {
+		if user.GetRole() == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// What a restricted credential such as an API key or a scoped token is allowed to do
+type Scope string
+
+const (
+	ScopeChirpsRead  Scope = "chirps:read"
+	ScopeChirpsWrite Scope = "chirps:write"
+	ScopeUsersWrite  Scope = "users:write"
+)
+
+var Scopes = []Scope{ScopeChirpsRead, ScopeChirpsWrite, ScopeUsersWrite}
+
+func (scope Scope) IsValid() bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// Reads a space separated list of scopes like the scope claim in a token
+func ParseScopes(scope string) ([]Scope, error) {
+	scopes := []Scope{}
+	for _, field := range strings.Fields(scope) {
+		if !Scope(field).IsValid() {
+			return nil, fmt.Errorf("unknown scope %q, choose from %v", field, Scopes)
+		}
+		if !slices.Contains(scopes, Scope(field)) {
+			scopes = append(scopes, Scope(field))
+		}
+	}
+	return scopes, nil
+}
+
+func FormatScopes(scopes []Scope) string {
+	fields := make([]string, len(scopes))
+	for i, scope := range scopes {
+		fields[i] = string(scope)
+	}
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tade3910/chirpy/util"
+)
+
+// A refresh token that has been exchanged for a new one. Kept until it would
+// have expired so presenting it again can be recognised as token theft.
+type RotatedSession struct {
+	UserId      int
+	FamilyId    string
+	TokenPrefix string
+	RotatedAt   time.Time
+	Expires     time.Time
+}
+
+type SecurityEvent struct {
+	Type    string
+	UserId  int
+	Details string
+	At      time.Time
+}
+
+const RefreshTokenReuse = "refresh_token_reuse"
+
+// Swaps the old refresh token for the new one within the same family
+func (database *Database) RotateSession(oldToken string, newToken string, newSession Session) {
+	oldHash := util.HashToken(oldToken)
+	old := database.Sessions[oldHash]
+	if old.FamilyId == "" {
+		// Sessions from before families existed start their own
+		old.FamilyId = oldHash
+	}
+	delete(database.Sessions, oldHash)
+	database.RotatedSessions[oldHash] = RotatedSession{
+		UserId:      old.User.Id,
+		FamilyId:    old.FamilyId,
+		TokenPrefix: old.TokenPrefix,
+		RotatedAt:   time.Now().UTC(),
+		Expires:     old.Expires,
+	}
+	newSession.FamilyId = old.FamilyId
+	newSession.CreatedAt = old.CreatedAt
+	database.PutSession(newToken, newSession)
+}
+
+func (database *Database) GetRotatedSession(token string) (RotatedSession, bool) {
+	rotated, exists := database.RotatedSessions[util.HashToken(token)]
+	return rotated, exists
+}
+
+// Removes every live session descended from the same login, returning how many were removed
+func (database *Database) RevokeFamily(familyId string) int {
+	revoked := 0
+	for token, session := range database.Sessions {
+		if session.FamilyId == familyId {
+			delete(database.Sessions, token)
+			revoked++
+		}
+	}
+	return revoked
+}
+
+func (database *Database) LogSecurityEvent(event SecurityEvent) {
+	event.At = time.Now().UTC()
+	fmt.Printf("Security event %s for user %d: %s\n", event.Type, event.UserId, event.Details)
+	database.SecurityEvents = append(database.SecurityEvents, event)
+}
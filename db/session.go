@@ -0,0 +1,169 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tade3910/chirpy/util"
+)
+
+// How long access tokens and sessions last. Sessions end once they go unrefreshed
+// for the idle timeout, or at the max lifetime however often they are refreshed.
+// Logins with remember me get the longer pair.
+type SessionPolicy struct {
+	AccessLifetime      time.Duration
+	IdleTimeout         time.Duration
+	MaxLifetime         time.Duration
+	RememberIdleTimeout time.Duration
+	RememberMaxLifetime time.Duration
+}
+
+func (policy SessionPolicy) Validate() error {
+	if policy.AccessLifetime <= 0 || policy.IdleTimeout <= 0 || policy.RememberIdleTimeout <= 0 {
+		return fmt.Errorf("token lifetimes and idle timeouts must be positive")
+	}
+	if policy.MaxLifetime < policy.IdleTimeout || policy.RememberMaxLifetime < policy.RememberIdleTimeout {
+		return fmt.Errorf("max session lifetimes can't be shorter than their idle timeouts")
+	}
+	return nil
+}
+
+func (policy SessionPolicy) lifetimes(rememberMe bool) (time.Duration, time.Duration) {
+	if rememberMe {
+		return policy.RememberIdleTimeout, policy.RememberMaxLifetime
+	}
+	return policy.IdleTimeout, policy.MaxLifetime
+}
+
+// When tokens revoked now stop mattering, as every access token issued before has expired
+func (policy SessionPolicy) RevokeUntil() time.Time {
+	return time.Now().UTC().Add(policy.AccessLifetime)
+}
+
+func minTime(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func (policy SessionPolicy) NewSession(user *User, familyId string, rememberMe bool, userAgent string, ip string) Session {
+	now := time.Now().UTC()
+	idle, max := policy.lifetimes(rememberMe)
+	return Session{
+		User:       user,
+		Expires:    now.Add(idle),
+		FamilyId:   familyId,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		UserAgent:  userAgent,
+		Ip:         ip,
+		MaxExpires: now.Add(max),
+		RememberMe: rememberMe,
+	}
+}
+
+// The session that replaces old on refresh. Everything the login decided is kept,
+// only the idle timeout starts over.
+func (policy SessionPolicy) RefreshSession(old Session, user *User, userAgent string, ip string) Session {
+	now := time.Now().UTC()
+	idle, _ := policy.lifetimes(old.RememberMe)
+	if old.MaxExpires.IsZero() {
+		// Sessions from before the max lifetime existed, normally seeded by migrateSessions
+		old.MaxExpires = old.Expires
+	}
+	old.User = user
+	old.Expires = minTime(now.Add(idle), old.MaxExpires)
+	old.LastUsedAt = now
+	old.UserAgent = userAgent
+	old.Ip = ip
+	return old
+}
+
+// How much of a refresh token is kept in the clear for display
+const TokenPrefixLength = 8
+
+func tokenPrefix(token string) string {
+	if len(token) < TokenPrefixLength {
+		return token
+	}
+	return token[:TokenPrefixLength]
+}
+
+// Stores the session under the hash of its refresh token
+func (database *Database) PutSession(token string, session Session) {
+	session.TokenPrefix = tokenPrefix(token)
+	database.Sessions[util.HashToken(token)] = session
+}
+
+func (database *Database) GetSession(token string) (Session, bool) {
+	session, exists := database.Sessions[util.HashToken(token)]
+	return session, exists
+}
+
+func (database *Database) DeleteSession(token string) {
+	delete(database.Sessions, util.HashToken(token))
+}
+
+// Rotation replaces a family's session, so the family id is what identifies
+// a logged in device to the user and what access tokens refer to
+func (database *Database) HasSession(familyId string, userId int) bool {
+	for _, session := range database.Sessions {
+		if session.FamilyId == familyId && session.User.Id == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the user's live sessions, most recently used first
+func (database *Database) GetUserSessions(userId int) []Session {
+	sessions := []Session{}
+	for _, session := range database.Sessions {
+		if session.User.Id == userId {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions
+}
+
+// Re-keys sessions stored before tokens were hashed. Those never had a
+// prefix, which is how they are told apart. Sessions from before the max
+// lifetime existed keep their old expiry as the max. Returns whether anything changed.
+func (database *Database) migrateSessions() bool {
+	migrated := false
+	for token, session := range database.Sessions {
+		if session.TokenPrefix != "" {
+			continue
+		}
+		delete(database.Sessions, token)
+		database.PutSession(token, session)
+		migrated = true
+	}
+	for hash, session := range database.Sessions {
+		if session.FamilyId == "" {
+			session.FamilyId = hash
+			database.Sessions[hash] = session
+			migrated = true
+		}
+		if session.MaxExpires.IsZero() {
+			session.MaxExpires = session.Expires
+			database.Sessions[hash] = session
+			migrated = true
+		}
+	}
+	for token, rotated := range database.RotatedSessions {
+		if rotated.TokenPrefix != "" {
+			continue
+		}
+		delete(database.RotatedSessions, token)
+		rotated.TokenPrefix = tokenPrefix(token)
+		database.RotatedSessions[util.HashToken(token)] = rotated
+		migrated = true
+	}
+	return migrated
+}
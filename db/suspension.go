@@ -0,0 +1,42 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+type Suspension struct {
+	// Nil for a permanent ban
+	Until       *time.Time `json:",omitempty"`
+	Reason      string
+	ModeratorId int
+	CreatedAt   time.Time
+	HideChirps  bool
+}
+
+func (suspension *Suspension) IsActive(now time.Time) bool {
+	return suspension != nil && (suspension.Until == nil || suspension.Until.After(now))
+}
+
+// What a suspended user is told when they are turned away
+func (suspension *Suspension) Error() error {
+	if suspension.Until == nil {
+		return fmt.Errorf("account is banned: %s", suspension.Reason)
+	}
+	return fmt.Errorf("account is suspended until %s: %s", suspension.Until.Format(time.RFC3339), suspension.Reason)
+}
+
+func (user *User) IsSuspended(now time.Time) bool {
+	return user.Suspension.IsActive(now)
+}
+
+// Whether viewerId should not be shown the chirp, because it is deleted, expired
+// or hidden by a moderator, its author is blocked or muted, or its author's
+// suspension hides it
+func (database *Database) IsChirpHiddenFrom(viewerId int, chirp Chirp, now time.Time) bool {
+	if !chirp.IsVisible(now) || chirp.Hidden || database.IsHiddenFrom(viewerId, chirp.AuthorId) {
+		return true
+	}
+	author, exists := database.IDUsersMap[chirp.AuthorId]
+	return exists && author.IsSuspended(now) && author.Suspension.HideChirps
+}
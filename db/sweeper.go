@@ -0,0 +1,116 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// Periodically removes expired chirps and chirps past their trash retention from the database
+func (database *Db) StartChirpSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			database.purgeChirps()
+		}
+	}()
+}
+
+func (database *Db) purgeChirps() {
+	now := time.Now().UTC()
+	retention := database.GetTrashRetention()
+	expired := 0
+	trashed := 0
+	ok := database.Update(func(readDatabase *Database) bool {
+		for id, chirp := range readDatabase.Chirps {
+			if chirp.IsExpired(now) {
+				delete(readDatabase.Chirps, id)
+				expired++
+			} else if chirp.DeletedAt != nil && !chirp.IsInTrash(now, retention) {
+				delete(readDatabase.Chirps, id)
+				trashed++
+			}
+		}
+		return expired > 0 || trashed > 0
+	})
+	if !ok {
+		fmt.Println("Sweeper could not update database")
+		return
+	}
+	database.mu.Lock()
+	database.purgedChirps += expired
+	database.purgedTrash += trashed
+	database.mu.Unlock()
+}
+
+func (database *Db) GetPurgedChirps() int {
+	database.mu.Lock()
+	defer database.mu.Unlock()
+	return database.purgedChirps
+}
+
+func (database *Db) GetPurgedTrash() int {
+	database.mu.Lock()
+	defer database.mu.Unlock()
+	return database.purgedTrash
+}
+
+// Removes expired sessions once straight away, then periodically. Rotated refresh
+// tokens, login challenges and authorization codes are only needed until they
+// expire too, so they go along with them.
+func (database *Db) StartSessionSweeper(interval time.Duration) {
+	database.purgeSessions()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			database.purgeSessions()
+		}
+	}()
+}
+
+func (database *Db) purgeSessions() {
+	now := time.Now().UTC()
+	expired := 0
+	ok := database.Update(func(readDatabase *Database) bool {
+		removed := 0
+		for hash, session := range readDatabase.Sessions {
+			if session.Expires.Before(now) {
+				delete(readDatabase.Sessions, hash)
+				expired++
+			}
+		}
+		for hash, rotated := range readDatabase.RotatedSessions {
+			if rotated.Expires.Before(now) {
+				delete(readDatabase.RotatedSessions, hash)
+				removed++
+			}
+		}
+		for hash, challenge := range readDatabase.LoginChallenges {
+			if challenge.Expires.Before(now) {
+				delete(readDatabase.LoginChallenges, hash)
+				removed++
+			}
+		}
+		for hash, code := range readDatabase.AuthorizationCodes {
+			if code.Expires.Before(now) {
+				delete(readDatabase.AuthorizationCodes, hash)
+				removed++
+			}
+		}
+		return expired > 0 || removed > 0
+	})
+	if !ok {
+		fmt.Println("Sweeper could not update database")
+		return
+	}
+	database.mu.Lock()
+	database.purgedSessions += expired
+	database.mu.Unlock()
+}
+
+func (database *Db) GetPurgedSessions() int {
+	database.mu.Lock()
+	defer database.mu.Unlock()
+	return database.purgedSessions
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"time"
+
+	"github.com/tade3910/chirpy/util"
+)
+
+// A user's authenticator app. The secret is kept in the clear since every
+// login needs it to compute codes, recovery codes are only kept hashed.
+type TwoFactor struct {
+	Secret  string
+	Enabled bool
+	// The last time step a code was accepted for, so a code only works once
+	LastStep           int64
+	RecoveryCodeHashes []string
+	EnabledAt          *time.Time `json:",omitempty"`
+}
+
+// Issued when the password was right but a second factor is still needed
+type LoginChallenge struct {
+	UserId   int
+	Expires  time.Time
+	Attempts int
+	// Scopes asked for at login, carried over to the session
+	Scopes     []Scope `json:",omitempty"`
+	RememberMe bool
+}
+
+// Wrong codes allowed against one challenge before it has to be started over
+const MaxChallengeAttempts = 5
+
+func (user *User) HasTwoFactor() bool {
+	return user.TwoFactor != nil && user.TwoFactor.Enabled
+}
+
+// Uses up a recovery code, returning whether it was one of the user's
+func (twoFactor *TwoFactor) ConsumeRecoveryCode(code string) bool {
+	hash := util.HashToken(code)
+	for i, existing := range twoFactor.RecoveryCodeHashes {
+		if existing == hash {
+			twoFactor.RecoveryCodeHashes = append(twoFactor.RecoveryCodeHashes[:i], twoFactor.RecoveryCodeHashes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Stores the challenge under the hash of its token
+func (database *Database) PutLoginChallenge(token string, challenge LoginChallenge) {
+	now := time.Now().UTC()
+	for hash, existing := range database.LoginChallenges {
+		if existing.Expires.Before(now) {
+			delete(database.LoginChallenges, hash)
+		}
+	}
+	database.LoginChallenges[util.HashToken(token)] = challenge
+}
+
+func (database *Database) GetLoginChallenge(token string) (LoginChallenge, bool) {
+	challenge, exists := database.LoginChallenges[util.HashToken(token)]
+	if !exists || challenge.Expires.Before(time.Now().UTC()) {
+		return LoginChallenge{}, false
+	}
+	return challenge, true
+}
+
+// Counts a wrong code, dropping the challenge once it runs out of attempts
+func (database *Database) FailLoginChallenge(token string) {
+	hash := util.HashToken(token)
+	challenge, exists := database.LoginChallenges[hash]
+	if !exists {
+		return
+	}
+	challenge.Attempts++
+	if challenge.Attempts >= MaxChallengeAttempts {
+		delete(database.LoginChallenges, hash)
+		return
+	}
+	database.LoginChallenges[hash] = challenge
+}
+
+func (database *Database) DeleteLoginChallenge(token string) {
+	delete(database.LoginChallenges, util.HashToken(token))
+}
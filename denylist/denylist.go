@@ -0,0 +1,97 @@
+package denylist
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Ids of revoked access tokens (jti) and sessions (sid), each kept only until
+// every access token it could cover has expired anyway. Checked on every
+// authenticated request so it lives in memory, and is written to a file so
+// revocations survive a restart.
+type Denylist struct {
+	mu      sync.RWMutex
+	path    string
+	entries map[string]time.Time
+}
+
+// Loads the denylist stored at path, starting empty when reset is set
+func GetDenylist(path string, reset bool) (*Denylist, bool) {
+	denylist := &Denylist{
+		path:    path,
+		entries: map[string]time.Time{},
+	}
+	if reset {
+		return denylist, denylist.save()
+	}
+	bytes, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return denylist, true
+	} else if err != nil {
+		fmt.Println("Problem reading denylist:", err)
+		return nil, false
+	}
+	if len(bytes) > 0 {
+		if err := json.Unmarshal(bytes, &denylist.entries); err != nil {
+			fmt.Println("Problem parsing denylist:", err)
+			return nil, false
+		}
+	}
+	denylist.mu.Lock()
+	defer denylist.mu.Unlock()
+	denylist.prune(time.Now().UTC())
+	return denylist, true
+}
+
+// Denies the ids until the given time, empty ids are ignored
+func (denylist *Denylist) Revoke(until time.Time, ids ...string) bool {
+	denylist.mu.Lock()
+	defer denylist.mu.Unlock()
+	denylist.prune(time.Now().UTC())
+	for _, id := range ids {
+		if id != "" && until.After(denylist.entries[id]) {
+			denylist.entries[id] = until
+		}
+	}
+	return denylist.saveLocked()
+}
+
+func (denylist *Denylist) IsRevoked(id string) bool {
+	if id == "" {
+		return false
+	}
+	denylist.mu.RLock()
+	defer denylist.mu.RUnlock()
+	until, exists := denylist.entries[id]
+	return exists && until.After(time.Now().UTC())
+}
+
+func (denylist *Denylist) prune(now time.Time) {
+	for id, until := range denylist.entries {
+		if !until.After(now) {
+			delete(denylist.entries, id)
+		}
+	}
+}
+
+func (denylist *Denylist) save() bool {
+	denylist.mu.Lock()
+	defer denylist.mu.Unlock()
+	return denylist.saveLocked()
+}
+
+func (denylist *Denylist) saveLocked() bool {
+	bytes, err := json.Marshal(denylist.entries)
+	if err != nil {
+		fmt.Println("Problem converting denylist to bytes")
+		return false
+	}
+	if err := os.WriteFile(denylist.path, bytes, 0600); err != nil {
+		fmt.Println("Problem writing denylist:", err)
+		return false
+	}
+	return true
+}
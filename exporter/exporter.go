@@ -0,0 +1,331 @@
+package exporter
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/util"
+)
+
+// Builds personal data archives on a fixed number of workers so large
+// exports never run on request goroutines
+type Exporter struct {
+	db           *db.Db
+	dir          string
+	linkLifetime time.Duration
+	jobs         chan string
+}
+
+// Starts workers that pull from a queue of queueSize exports. Archives are
+// written to dir and deleted linkLifetime after they are ready.
+func GetExporter(db *db.Db, dir string, workers int, queueSize int, linkLifetime time.Duration) *Exporter {
+	exporter := &Exporter{
+		db:           db,
+		dir:          dir,
+		linkLifetime: linkLifetime,
+		jobs:         make(chan string, queueSize),
+	}
+	exporter.requeue()
+	for i := 0; i < workers; i++ {
+		go exporter.work()
+	}
+	go exporter.cleanup()
+	return exporter
+}
+
+// Puts exports left pending or running by a previous process back on the
+// queue. Any that no longer fit are marked failed so the user can ask again.
+func (exporter *Exporter) requeue() {
+	exporter.db.Update(func(database *db.Database) bool {
+		changed := false
+		for id, export := range database.Exports {
+			if export.Status != db.ExportPending && export.Status != db.ExportRunning {
+				continue
+			}
+			select {
+			case exporter.jobs <- id:
+				export.Status = db.ExportPending
+			default:
+				fmt.Println("Export queue is full, failing interrupted export", id)
+				export.Status = db.ExportFailed
+			}
+			database.Exports[id] = export
+			changed = true
+		}
+		return changed
+	})
+}
+
+// Queues an export for the user, reusing one that is already in progress
+func (exporter *Exporter) Enqueue(userId int) (db.Export, int, error) {
+	id, err := util.CreateRandomString(16)
+	if err != nil {
+		return db.Export{}, 500, fmt.Errorf("could not create export id")
+	}
+	export := db.Export{
+		Id:        id,
+		UserId:    userId,
+		Status:    db.ExportPending,
+		CreatedAt: time.Now().UTC(),
+	}
+	queued := false
+	if !exporter.db.Update(func(database *db.Database) bool {
+		if unfinished, exists := database.GetUnfinishedExport(userId); exists {
+			export = unfinished
+			return false
+		}
+		database.Exports[id] = export
+		queued = true
+		return true
+	}) {
+		return db.Export{}, 500, fmt.Errorf("could not update database")
+	}
+	if !queued {
+		return export, 202, nil
+	}
+	select {
+	case exporter.jobs <- id:
+		return export, 202, nil
+	default:
+		exporter.remove(id)
+		return db.Export{}, 503, fmt.Errorf("too many exports in progress, try again later")
+	}
+}
+
+// Mints a new download token for a ready export, invalidating older links
+func (exporter *Exporter) CreateDownloadToken(exportId string) (string, bool) {
+	token, err := util.CreateRandomString(32)
+	if err != nil {
+		return "", false
+	}
+	ready := false
+	if !exporter.db.Update(func(database *db.Database) bool {
+		export, exists := database.Exports[exportId]
+		if !exists || export.Status != db.ExportReady {
+			return false
+		}
+		export.DownloadTokenHash = util.HashToken(token)
+		database.Exports[exportId] = export
+		ready = true
+		return true
+	}) {
+		return "", false
+	}
+	return token, ready
+}
+
+// Returns the archive path when the token is the export's current, unexpired download token
+func (exporter *Exporter) GetArchive(exportId string, token string) (string, bool) {
+	database, ok := exporter.db.GetDatabase()
+	if !ok {
+		return "", false
+	}
+	export, exists := database.Exports[exportId]
+	if !exists || export.Status != db.ExportReady || export.Expires.Before(time.Now().UTC()) {
+		return "", false
+	}
+	if export.DownloadTokenHash == "" || export.DownloadTokenHash != util.HashToken(token) {
+		return "", false
+	}
+	return exporter.archivePath(exportId), true
+}
+
+func (exporter *Exporter) archivePath(exportId string) string {
+	return filepath.Join(exporter.dir, exportId+".zip")
+}
+
+func (exporter *Exporter) remove(exportId string) {
+	exporter.db.Update(func(database *db.Database) bool {
+		delete(database.Exports, exportId)
+		return true
+	})
+}
+
+func (exporter *Exporter) work() {
+	for exportId := range exporter.jobs {
+		exporter.build(exportId)
+	}
+}
+
+func (exporter *Exporter) build(exportId string) {
+	var files map[string]interface{}
+	if !exporter.db.Update(func(database *db.Database) bool {
+		export, exists := database.Exports[exportId]
+		if !exists {
+			return false
+		}
+		export.Status = db.ExportRunning
+		database.Exports[exportId] = export
+		files = collect(database, export.UserId)
+		return true
+	}) {
+		fmt.Println("Exporter could not update database")
+		return
+	}
+	if files == nil {
+		return
+	}
+
+	status := db.ExportReady
+	if err := exporter.writeArchive(exportId, files); err != nil {
+		fmt.Println("Problem writing export:", err)
+		os.Remove(exporter.archivePath(exportId) + ".tmp")
+		status = db.ExportFailed
+	}
+	// The database may have changed while the archive was written
+	deleted := false
+	exporter.db.Update(func(database *db.Database) bool {
+		export, exists := database.Exports[exportId]
+		if !exists {
+			deleted = true
+			return false
+		}
+		export.Status = status
+		if status == db.ExportReady {
+			expires := time.Now().UTC().Add(exporter.linkLifetime)
+			export.Expires = &expires
+		}
+		database.Exports[exportId] = export
+		return true
+	})
+	if deleted {
+		os.Remove(exporter.archivePath(exportId))
+	}
+}
+
+// Everything the archive holds, keyed by file name inside the zip
+func collect(database *db.Database, userId int) map[string]interface{} {
+	user, exists := database.IDUsersMap[userId]
+	if !exists {
+		return map[string]interface{}{}
+	}
+	chirps := []db.Chirp{}
+	for _, chirp := range database.Chirps {
+		if chirp.AuthorId == userId {
+			chirps = append(chirps, chirp)
+		}
+	}
+	sort.Slice(chirps, func(i, j int) bool {
+		return chirps[i].Id < chirps[j].Id
+	})
+	// Only metadata, the tokens themselves are credentials
+	type sessionMetadata struct {
+		UserAgent  string
+		Ip         string
+		CreatedAt  time.Time
+		LastUsedAt time.Time
+		Expires    time.Time
+	}
+	sessions := []sessionMetadata{}
+	for _, session := range database.GetUserSessions(userId) {
+		sessions = append(sessions, sessionMetadata{
+			UserAgent:  session.UserAgent,
+			Ip:         session.Ip,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			Expires:    session.Expires,
+		})
+	}
+	subscriptions := user.Subscriptions
+	if subscriptions == nil {
+		subscriptions = []db.SubscriptionEvent{}
+	}
+	return map[string]interface{}{
+		"profile.json":       user.PlainUser,
+		"chirps.json":        chirps,
+		"sessions.json":      sessions,
+		"subscriptions.json": subscriptions,
+		"blocks.json":        database.GetBlocks(userId),
+		"mutes.json":         database.GetMutes(userId),
+	}
+}
+
+// Writes to a temporary file first so a half written archive is never served
+func (exporter *Exporter) writeArchive(exportId string, files map[string]interface{}) error {
+	if err := os.MkdirAll(exporter.dir, 0700); err != nil {
+		return err
+	}
+	path := exporter.archivePath(exportId)
+	file, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	archive := zip.NewWriter(file)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content, err := json.MarshalIndent(files[name], "", "  ")
+		if err != nil {
+			file.Close()
+			return err
+		}
+		entry, err := archive.Create(name)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		if _, err := entry.Write(content); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Deletes expired archives along with archives whose export no longer exists
+func (exporter *Exporter) cleanup() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now().UTC()
+		exporter.db.Update(func(database *db.Database) bool {
+			changed := false
+			for id, export := range database.Exports {
+				expired := export.Expires != nil && export.Expires.Before(now)
+				failed := export.Status == db.ExportFailed && export.CreatedAt.Add(exporter.linkLifetime).Before(now)
+				if expired || failed {
+					delete(database.Exports, id)
+					changed = true
+				}
+			}
+			return changed
+		})
+		entries, err := os.ReadDir(exporter.dir)
+		if err != nil {
+			continue
+		}
+		// Read after listing, exports are stored before their archive is written
+		// so every archive listed belongs to an export this read knows about
+		database, ok := exporter.db.GetDatabase()
+		if !ok {
+			continue
+		}
+		for _, entry := range entries {
+			// Still being written, build cleans up after itself
+			if strings.HasSuffix(entry.Name(), ".tmp") {
+				continue
+			}
+			exportId := strings.TrimSuffix(entry.Name(), ".zip")
+			if _, exists := database.Exports[exportId]; !exists {
+				os.Remove(filepath.Join(exporter.dir, entry.Name()))
+			}
+		}
+	}
+}
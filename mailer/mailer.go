@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// Writes mail to a file instead of sending it, meant for local development
+type logMailer struct {
+	path string
+}
+
+// An empty path prints mail to stdout
+func GetLogMailer(path string) *logMailer {
+	return &logMailer{
+		path: path,
+	}
+}
+
+func (mailer *logMailer) Send(to string, subject string, body string) error {
+	message := fmt.Sprintf("[%s] To: %s\nSubject: %s\n\n%s\n\n", time.Now().UTC().Format(time.RFC3339), to, subject, body)
+	if mailer.path == "" {
+		fmt.Print(message)
+		return nil
+	}
+	file, err := os.OpenFile(mailer.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(message)
+	return err
+}
@@ -1,20 +1,40 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/denylist"
+	"github.com/tade3910/chirpy/exporter"
+	"github.com/tade3910/chirpy/mailer"
 	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/password"
 	polka "github.com/tade3910/chirpy/routes/Polka"
+	"github.com/tade3910/chirpy/routes/admin"
+	"github.com/tade3910/chirpy/routes/apikeys"
 	"github.com/tade3910/chirpy/routes/chirp"
 	"github.com/tade3910/chirpy/routes/chirps"
+	"github.com/tade3910/chirpy/routes/export"
+	"github.com/tade3910/chirpy/routes/jwks"
 	"github.com/tade3910/chirpy/routes/login"
+	"github.com/tade3910/chirpy/routes/oauth"
 	"github.com/tade3910/chirpy/routes/refresh"
+	"github.com/tade3910/chirpy/routes/reports"
+	"github.com/tade3910/chirpy/routes/reset"
+	"github.com/tade3910/chirpy/routes/sessions"
+	"github.com/tade3910/chirpy/routes/trash"
+	"github.com/tade3910/chirpy/routes/user"
 	"github.com/tade3910/chirpy/routes/users"
+	"github.com/tade3910/chirpy/routes/verify"
+	"github.com/tade3910/chirpy/signing"
 )
 
 type HealthHandler struct {
@@ -30,30 +50,257 @@ func (handler *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	w.Write([]byte("OK"))
 }
 
+// Reads a duration such as "30s" from the env, falling back when unset or invalid
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		fmt.Printf("Invalid %s %q, using %s\n", key, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+func getEnvString(key string, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Printf("Invalid %s %q, using %d\n", key, value, fallback)
+		return fallback
+	}
+	return number
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		fmt.Printf("Invalid %s %q, using %t\n", key, value, fallback)
+		return fallback
+	}
+	return flag
+}
+
+// SMTP when MAILER=smtp, otherwise mail is written to MAIL_LOG_PATH or stdout
+func getMailer() mailer.Mailer {
+	if os.Getenv("MAILER") == "smtp" {
+		return mailer.GetSmtpMailer(
+			os.Getenv("SMTP_HOST"),
+			os.Getenv("SMTP_PORT"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+			os.Getenv("MAIL_FROM"),
+		)
+	}
+	return mailer.GetLogMailer(os.Getenv("MAIL_LOG_PATH"))
+}
+
+// Signs with JWT_SIGNING_KEY when set, otherwise with the shared JWT_SECRET.
+// JWT_VERIFICATION_KEYS lists id=path pairs of older keys still accepted.
+func getSigningKeys(jwtSecret string) (*signing.KeySet, error) {
+	signingKeyPath := os.Getenv("JWT_SIGNING_KEY")
+	if signingKeyPath == "" {
+		if jwtSecret == "" {
+			return nil, fmt.Errorf("either JWT_SECRET or JWT_SIGNING_KEY must be set")
+		}
+		return signing.GetHmacKeySet(jwtSecret), nil
+	}
+	verificationKeyPaths := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv("JWT_VERIFICATION_KEYS"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		id, path, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || id == "" || path == "" {
+			return nil, fmt.Errorf("JWT_VERIFICATION_KEYS entries must look like id=path, got %q", pair)
+		}
+		verificationKeyPaths[id] = path
+	}
+	return signing.LoadKeySet(os.Getenv("JWT_SIGNING_KEY_ID"), signingKeyPath, verificationKeyPaths, jwtSecret)
+}
+
 func main() {
+	debug := flag.Bool("debug", false, "Delete the database on startup")
+	flag.Parse()
 	godotenv.Load()
 	port := os.Getenv("PORT")
 	jwtSecret := os.Getenv("JWT_SECRET")
 	polkaKey := os.Getenv("POLKA_KEY")
-	if port == "" || jwtSecret == "" {
+	avatarDir := getEnvString("AVATAR_DIR", "avatars")
+	reregisterCooldown := getEnvDuration("REREGISTER_COOLDOWN", 30*24*time.Hour)
+	publicUrl := getEnvString("PUBLIC_URL", "http://localhost:"+port)
+	unverifiedAccess := apiConfig.UnverifiedAccess(os.Getenv("UNVERIFIED_ACCESS"))
+	switch unverifiedAccess {
+	case apiConfig.UnverifiedFull, apiConfig.UnverifiedReadOnly, apiConfig.UnverifiedNone:
+	case "":
+		unverifiedAccess = apiConfig.UnverifiedFull
+	default:
+		log.Fatalf("Unknown UNVERIFIED_ACCESS %q", unverifiedAccess)
+	}
+	chirpDeletionPolicy := db.ChirpDeletionPolicy(os.Getenv("CHIRP_DELETION_POLICY"))
+	switch chirpDeletionPolicy {
+	case db.DeleteChirps, db.AnonymizeChirps:
+	case "":
+		chirpDeletionPolicy = db.DeleteChirps
+	default:
+		log.Fatalf("Unknown CHIRP_DELETION_POLICY %q", chirpDeletionPolicy)
+	}
+	passwordPolicy := password.Policy{
+		MinLength:        getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		RequireDigit:     getEnvBool("PASSWORD_REQUIRE_DIGIT", false),
+		RequireSymbol:    getEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+		RequireMixedCase: getEnvBool("PASSWORD_REQUIRE_MIXED_CASE", false),
+	}
+	hasher := password.Hasher{
+		Algorithm:  password.Algorithm(getEnvString("PASSWORD_HASH", string(password.Bcrypt))),
+		BcryptCost: getEnvInt("BCRYPT_COST", password.DefaultBcryptCost),
+		Argon2: password.Argon2Params{
+			Memory:      uint32(getEnvInt("ARGON2_MEMORY_KIB", int(password.DefaultArgon2.Memory))),
+			Iterations:  uint32(getEnvInt("ARGON2_ITERATIONS", int(password.DefaultArgon2.Iterations))),
+			Parallelism: uint8(getEnvInt("ARGON2_PARALLELISM", int(password.DefaultArgon2.Parallelism))),
+			SaltLength:  password.DefaultArgon2.SaltLength,
+			KeyLength:   password.DefaultArgon2.KeyLength,
+		},
+	}
+	if err := hasher.Validate(); err != nil {
+		log.Fatal("Invalid password hashing settings: ", err)
+	}
+	sessionPolicy := db.SessionPolicy{
+		AccessLifetime:      getEnvDuration("ACCESS_TOKEN_TTL", time.Hour),
+		IdleTimeout:         getEnvDuration("SESSION_IDLE_TIMEOUT", 24*time.Hour),
+		MaxLifetime:         getEnvDuration("SESSION_MAX_LIFETIME", 7*24*time.Hour),
+		RememberIdleTimeout: getEnvDuration("REMEMBER_ME_IDLE_TIMEOUT", 30*24*time.Hour),
+		RememberMaxLifetime: getEnvDuration("REMEMBER_ME_MAX_LIFETIME", 60*24*time.Hour),
+	}
+	if err := sessionPolicy.Validate(); err != nil {
+		log.Fatal("Invalid session settings: ", err)
+	}
+	database, ok := db.GetDb(getEnvDuration("TRASH_RETENTION", 30*24*time.Hour), *debug)
+	if !ok {
+		log.Fatal("Could not connect to database")
+	}
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args(), database); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if port == "" {
 		log.Fatal("No Port found in env")
 	}
+	keys, err := getSigningKeys(jwtSecret)
+	if err != nil {
+		log.Fatal("Could not load JWT keys: ", err)
+	}
+	validation := signing.Validation{
+		Issuer:   getEnvString("JWT_ISSUER", "chirpy"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+		Leeway:   getEnvDuration("JWT_LEEWAY", 0),
+		MaxAge:   getEnvDuration("JWT_MAX_AGE", 0),
+	}
+	if algorithms := os.Getenv("JWT_ALGORITHMS"); algorithms != "" {
+		for _, algorithm := range strings.Split(algorithms, ",") {
+			validation.Algorithms = append(validation.Algorithms, strings.TrimSpace(algorithm))
+		}
+	}
+	if err := keys.SetValidation(validation); err != nil {
+		log.Fatal("Invalid JWT validation settings: ", err)
+	}
+	database.StartChirpSweeper(getEnvDuration("CHIRP_SWEEP_INTERVAL", time.Minute))
+	database.StartSessionSweeper(getEnvDuration("SESSION_SWEEP_INTERVAL", time.Hour))
+	revoked, ok := denylist.GetDenylist(getEnvString("DENYLIST_PATH", "denylist.json"), *debug)
+	if !ok {
+		log.Fatal("Could not load token denylist")
+	}
+	lockoutPolicy := db.LockoutPolicy{
+		AccountThreshold: getEnvInt("LOGIN_MAX_FAILURES", 5),
+		IpThreshold:      getEnvInt("LOGIN_MAX_FAILURES_PER_IP", 20),
+		Lockout:          getEnvDuration("LOGIN_LOCKOUT", time.Minute),
+		MaxLockout:       getEnvDuration("LOGIN_MAX_LOCKOUT", time.Hour),
+		Window:           getEnvDuration("LOGIN_FAILURE_WINDOW", time.Hour),
+	}
+	lockouts := db.GetLockouts(lockoutPolicy)
+	credentials := login.GetCredentialChecker(database, hasher, lockouts)
 	router := http.NewServeMux()
-	apiCfg := apiConfig.GetApiConfig(jwtSecret, polkaKey)
+	apiCfg := apiConfig.GetApiConfig(keys, polkaKey, unverifiedAccess, database, revoked)
+	mailer := getMailer()
+	exportService := exporter.GetExporter(
+		database,
+		getEnvString("EXPORT_DIR", "exports"),
+		getEnvInt("EXPORT_WORKERS", 2),
+		getEnvInt("EXPORT_QUEUE_SIZE", 16),
+		getEnvDuration("EXPORT_LINK_TTL", 24*time.Hour),
+	)
+	verifier := verify.GetVerifier(database, mailer, publicUrl, getEnvDuration("VERIFICATION_TOKEN_TTL", 24*time.Hour))
 	router.Handle("/app/*", apiCfg.MiddlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(".")))))
 	router.Handle("/api/healthz", &HealthHandler{})
-	db, ok := db.GetDb()
-	if !ok {
-		log.Fatal("Could not connect to database")
-	}
-	router.Handle("/api/chirps", apiCfg.EnsureAuthenticated(chirps.GetChirpsHandler(db)))
-	router.Handle("/api/chirps/", apiCfg.EnsureAuthenticated(chirp.GetChirpHandler(db)))
-	router.Handle("/api/users", apiCfg.EnsureAuthenticated(users.GetUsersHandler(db)))
-	router.Handle("/api/login", apiCfg.WithJwtSecret(login.GetLoginHandler(db)))
-	router.Handle("/api/refresh", apiCfg.WithJwtSecret(refresh.GetRefreshHandler(db)))
-	router.Handle("/api/polka/webhooks", apiCfg.CheckPolkaKey(polka.GetPolkaHandler(db)))
-	router.HandleFunc("/admin/metrics", apiCfg.HandleMetrics)
-	router.HandleFunc("/api/reset", apiCfg.HandleReset)
+	router.Handle("/.well-known/jwks.json", jwks.GetJwksHandler(keys))
+	router.Handle("/.well-known/oauth-authorization-server", oauth.GetMetadataHandler(publicUrl))
+	// API keys and scoped tokens only reach the routes their scopes cover
+	chirpScopes := apiConfig.RouteScopes{Read: db.ScopeChirpsRead, Write: db.ScopeChirpsWrite}
+	userScopes := apiConfig.RouteScopes{Write: db.ScopeUsersWrite}
+	router.Handle("/api/chirps", apiCfg.EnsureScoped(chirpScopes, chirps.GetChirpsHandler(database)))
+	router.Handle("/api/chirps/", apiCfg.EnsureScoped(chirpScopes, chirp.GetChirpHandler(database)))
+	router.Handle("/api/chirps/{id}/restore", apiCfg.EnsureScoped(chirpScopes, chirp.GetRestoreHandler(database)))
+	router.Handle("/api/chirps/{id}/reports", apiCfg.EnsureAuthenticated(reports.GetReportsHandler(database, db.ChirpTarget)))
+	router.Handle("/api/users", apiCfg.EnsureAuthenticated(users.GetUsersHandler(database, passwordPolicy, hasher, verifier, reregisterCooldown)))
+	router.Handle("/api/users/", apiCfg.EnsureScoped(userScopes, user.GetUserHandler(database, chirpDeletionPolicy, avatarDir, credentials, verifier, reregisterCooldown)))
+	router.Handle("/api/users/{id}/reports", apiCfg.EnsureAuthenticated(reports.GetReportsHandler(database, db.UserTarget)))
+	router.Handle("/api/users/me/trash", apiCfg.EnsureScoped(chirpScopes, trash.GetTrashHandler(database)))
+	router.Handle("/api/users/me/2fa", apiCfg.EnsureAuthenticated(user.GetTwoFactorHandler(database, credentials)))
+	router.Handle("/api/users/me/2fa/confirm", apiCfg.EnsureAuthenticated(user.GetTwoFactorConfirmHandler(database)))
+	router.Handle("/api/users/me/password", apiCfg.EnsureAuthenticated(user.GetPasswordHandler(database, passwordPolicy, hasher, credentials, sessionPolicy)))
+	router.Handle("/api/users/me/avatar", apiCfg.EnsureScoped(userScopes, user.GetAvatarHandler(database, avatarDir)))
+	router.Handle("/api/users/me/api-keys", apiCfg.EnsureAuthenticated(apikeys.GetApiKeysHandler(database)))
+	router.Handle("/api/users/me/api-keys/{id}", apiCfg.EnsureAuthenticated(apikeys.GetApiKeysHandler(database)))
+	router.Handle("/api/users/me/export", apiCfg.EnsureAuthenticated(export.GetExportHandler(database, exportService)))
+	router.Handle("/api/users/me/export/{id}", apiCfg.EnsureAuthenticated(export.GetExportHandler(database, exportService)))
+	router.Handle("/api/exports/{id}", export.GetDownloadHandler(exportService))
+	router.Handle("/api/sessions", apiCfg.EnsureAuthenticated(sessions.GetSessionsHandler(database, revoked, sessionPolicy)))
+	router.Handle("/api/sessions/{id}", apiCfg.EnsureAuthenticated(sessions.GetSessionsHandler(database, revoked, sessionPolicy)))
+	router.Handle(user.AvatarUrlPrefix, http.StripPrefix(user.AvatarUrlPrefix, http.FileServer(http.Dir(avatarDir))))
+	router.Handle("/api/verify", verify.GetVerifyHandler(verifier))
+	router.Handle("/api/verify/resend", apiCfg.EnsureAuthenticated(verify.GetResendHandler(verifier)))
+	router.Handle("/api/password-reset", reset.GetResetHandler(database, mailer, getEnvDuration("RESET_TOKEN_TTL", time.Hour)))
+	router.Handle("/api/password-reset/confirm", reset.GetConfirmHandler(database, passwordPolicy, hasher))
+	router.Handle("/api/login", apiCfg.WithSigningKeys(login.GetLoginHandler(database, getEnvDuration("LOGIN_CHALLENGE_TTL", 5*time.Minute), credentials, sessionPolicy)))
+	router.Handle("/api/login/2fa", apiCfg.WithSigningKeys(login.GetTwoFactorHandler(database, credentials, sessionPolicy)))
+	router.Handle("/api/refresh", apiCfg.WithSigningKeys(refresh.GetRefreshHandler(database, revoked, sessionPolicy)))
+	router.Handle("/api/oauth/clients", apiCfg.EnsureAuthenticated(oauth.GetClientsHandler(database, revoked, sessionPolicy)))
+	router.Handle("/api/oauth/clients/{id}", apiCfg.EnsureAuthenticated(oauth.GetClientsHandler(database, revoked, sessionPolicy)))
+	router.Handle("/oauth/authorize", oauth.GetAuthorizeHandler(database, credentials, getEnvDuration("OAUTH_CODE_TTL", time.Minute)))
+	router.Handle("/oauth/token", apiCfg.WithSigningKeys(oauth.GetTokenHandler(database, revoked, sessionPolicy)))
+	router.Handle("/oauth/introspect", apiCfg.WithSigningKeys(oauth.GetIntrospectHandler(database, revoked)))
+	router.Handle("/oauth/revoke", apiCfg.WithSigningKeys(oauth.GetRevokeHandler(database, revoked, sessionPolicy)))
+	router.Handle("/api/polka/webhooks", apiCfg.CheckPolkaKey(polka.GetPolkaHandler(database)))
+	router.Handle("/admin/metrics", apiCfg.RequireRole(db.RoleAdmin, http.HandlerFunc(apiCfg.HandleMetrics)))
+	router.Handle("/admin/chirps/{id}", apiCfg.RequireRole(db.RoleModerator, admin.GetChirpHandler(database)))
+	router.Handle("/admin/users/{id}/role", apiCfg.RequireRole(db.RoleAdmin, admin.GetRoleHandler(database)))
+	router.Handle("/admin/users/{id}/suspension", apiCfg.RequireRole(db.RoleModerator, admin.GetSuspensionHandler(database)))
+	router.Handle("/admin/reports", apiCfg.RequireRole(db.RoleModerator, admin.GetReportsHandler(database)))
+	router.Handle("/admin/reports/{id}/{action}", apiCfg.RequireRole(db.RoleModerator, admin.GetReportsHandler(database)))
+	router.Handle("/admin/audit", apiCfg.RequireRole(db.RoleModerator, admin.GetAuditHandler(database)))
+	router.Handle("/admin/lockouts", apiCfg.RequireRole(db.RoleAdmin, admin.GetLockoutsHandler(database, lockouts)))
+	router.Handle("/admin/lockouts/{kind}/{subject}", apiCfg.RequireRole(db.RoleAdmin, admin.GetLockoutsHandler(database, lockouts)))
+	router.Handle("/admin/security-events", apiCfg.RequireRole(db.RoleAdmin, admin.GetSecurityHandler(database)))
+	router.Handle("/api/reset", apiCfg.RequireRole(db.RoleAdmin, http.HandlerFunc(apiCfg.HandleReset)))
 	server := &http.Server{
 		Addr:    ":" + port,
 		Handler: router,
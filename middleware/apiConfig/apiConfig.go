@@ -4,36 +4,73 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/denylist"
+	"github.com/tade3910/chirpy/signing"
 	"github.com/tade3910/chirpy/util"
 )
 
 type contextKey string
 
 const (
-	UserId    contextKey = "userId"
-	JwtSecret contextKey = "jwtSecret"
+	UserId      contextKey = "userId"
+	Role        contextKey = "role"
+	SessionId   contextKey = "sessionId"
+	Scopes      contextKey = "scopes"
+	SigningKeys contextKey = "signingKeys"
+)
+
+// How much of the api users can use before verifying their email
+type UnverifiedAccess string
+
+const (
+	UnverifiedFull     UnverifiedAccess = "full"
+	UnverifiedReadOnly UnverifiedAccess = "read-only"
+	UnverifiedNone     UnverifiedAccess = "none"
 )
 
 type apiConfig struct {
-	fileserverHits int
-	jwtSecret      string
-	polkaKey       string
-	mu             sync.Mutex
+	fileserverHits   int
+	keys             *signing.KeySet
+	polkaKey         string
+	unverifiedAccess UnverifiedAccess
+	db               *db.Db
+	denylist         *denylist.Denylist
+	mu               sync.Mutex
 }
 
-func GetApiConfig(JwtSecret string, polkaKey string) *apiConfig {
+func GetApiConfig(keys *signing.KeySet, polkaKey string, unverifiedAccess UnverifiedAccess, db *db.Db, denylist *denylist.Denylist) *apiConfig {
 	return &apiConfig{
-		jwtSecret: JwtSecret,
-		polkaKey:  polkaKey,
+		keys:             keys,
+		polkaKey:         polkaKey,
+		unverifiedAccess: unverifiedAccess,
+		db:               db,
+		denylist:         denylist,
+	}
+}
+
+// Returns the id of the user authenticated by EnsureAuthenticated
+func GetUserId(r *http.Request) (int, error) {
+	userIdString, ok := r.Context().Value(UserId).(string)
+	if !ok {
+		return 0, fmt.Errorf("no authenticated user in request context")
 	}
+	return strconv.Atoi(userIdString)
 }
 
-func (cfg *apiConfig) WithJwtSecret(next http.Handler) http.Handler {
+// Returns the session the request's access token was issued for, if any
+func GetSessionId(r *http.Request) string {
+	sessionId, _ := r.Context().Value(SessionId).(string)
+	return sessionId
+}
+
+func (cfg *apiConfig) WithSigningKeys(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := context.WithValue(r.Context(), JwtSecret, cfg.jwtSecret)
+		ctx := context.WithValue(r.Context(), SigningKeys, cfg.keys)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -42,31 +79,131 @@ func excludeRoutes(r *http.Request) bool {
 	return r.URL.Path == "/api/users" && r.Method == http.MethodPost
 }
 
+// Unverified users can always see their account and ask for a new verification link
+func verificationRoutes(r *http.Request) bool {
+	return (r.URL.Path == "/api/users/me" && r.Method == http.MethodGet) ||
+		(r.URL.Path == "/api/verify/resend" && r.Method == http.MethodPost)
+}
+
+// Tokens stay valid after the account changes, so the stored user is checked
+// on every request to catch deleted, suspended and unverified accounts
+func (cfg *apiConfig) checkAccount(r *http.Request, userId string, sessionId string) (int, error) {
+	id, err := strconv.Atoi(userId)
+	if err != nil {
+		return 401, fmt.Errorf("userId could not be parsed from token")
+	}
+	database, ok := cfg.db.GetDatabase()
+	if !ok {
+		return 500, fmt.Errorf("could not read from database")
+	}
+	user, exists := database.IDUsersMap[id]
+	if !exists {
+		return 401, fmt.Errorf("user no longer exists")
+	}
+	// Revocations made through the api go on the denylist, this catches
+	// sessions removed along with the account, a password reset or a suspension
+	if sessionId != "" && !database.HasSession(sessionId, id) {
+		return 401, fmt.Errorf("session has been revoked")
+	}
+	if user.IsSuspended(time.Now().UTC()) {
+		return 403, user.Suspension.Error()
+	}
+	if user.Is_verified || cfg.unverifiedAccess == UnverifiedFull || verificationRoutes(r) {
+		return 200, nil
+	}
+	if cfg.unverifiedAccess == UnverifiedReadOnly && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		return 200, nil
+	}
+	return 403, fmt.Errorf("email must be verified first")
+}
+
+// Who a request is from. Scopes is nil for full logins and lists what
+// restricted credentials like API keys may do.
+type identity struct {
+	userId    string
+	role      string
+	sessionId string
+	scopes    []db.Scope
+}
+
+func (cfg *apiConfig) checkAccessToken(w http.ResponseWriter, r *http.Request) (identity, bool) {
+	tokenString, err := util.GetAuthToken(r, util.Bearer)
+	if err != nil {
+		util.RespondWithErrorCode(w, 401, string(signing.TokenMissing), err.Error())
+		return identity{}, false
+	}
+	claims := &util.Claims{}
+	if validationErr := cfg.keys.Parse(tokenString, claims); validationErr != nil {
+		util.RespondWithErrorCode(w, 401, string(validationErr.Code), validationErr.Message)
+		return identity{}, false
+	}
+	if cfg.denylist.IsRevoked(claims.ID) || cfg.denylist.IsRevoked(claims.SessionId) {
+		util.RespondWithErrorCode(w, 401, string(signing.TokenRevoked), "token has been revoked")
+		return identity{}, false
+	}
+	userId, err := claims.GetSubject()
+	if err != nil || userId == "" {
+		util.RespondWithErrorCode(w, 401, string(signing.TokenInvalid), "userId could not be parsed from token")
+		return identity{}, false
+	}
+	caller := identity{
+		userId:    userId,
+		role:      claims.Role,
+		sessionId: claims.SessionId,
+	}
+	// A token with a scope claim is restricted to it like an API key
+	if claims.Scope != "" {
+		scopes, err := db.ParseScopes(claims.Scope)
+		if err != nil {
+			util.RespondWithErrorCode(w, 401, string(signing.TokenInvalid), err.Error())
+			return identity{}, false
+		}
+		caller.scopes = scopes
+	}
+	return caller, true
+}
+
 func (cfg *apiConfig) EnsureAuthenticated(next http.Handler) http.Handler {
+	return cfg.authenticate(nil, next)
+}
+
+// Like EnsureAuthenticated, also letting in restricted credentials that hold the scope the route needs
+func (cfg *apiConfig) EnsureScoped(scopes RouteScopes, next http.Handler) http.Handler {
+	return cfg.authenticate(&scopes, next)
+}
+
+// Restricted credentials are refused unless the route lists scopes
+func (cfg *apiConfig) authenticate(scopes *RouteScopes, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// If a new user is created they don't have token
 		if excludeRoutes(r) {
 			next.ServeHTTP(w, r)
 			return
 		}
-		tokenString, err := util.GetAuthToken(r, util.Bearer)
-		if err != nil {
-			util.RespondWithError(w, 401, err.Error())
-			return
+		var caller identity
+		var ok bool
+		if isApiKeyRequest(r) {
+			caller, ok = cfg.checkApiKey(w, r)
+		} else {
+			caller, ok = cfg.checkAccessToken(w, r)
 		}
-		token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.jwtSecret), nil
-		})
-		if err != nil {
-			util.RespondWithError(w, 401, "Error parsing auth token")
+		if !ok {
 			return
 		}
-		userId, err := token.Claims.GetSubject()
-		if err != nil {
-			util.RespondWithError(w, 401, "userId could not be parsed from token")
+		if caller.scopes != nil {
+			if statusCode, err := checkScopes(r, scopes, caller.scopes); err != nil {
+				util.RespondWithErrorCode(w, statusCode, insufficientScope, err.Error())
+				return
+			}
+		}
+		if statusCode, err := cfg.checkAccount(r, caller.userId, caller.sessionId); err != nil {
+			util.RespondWithError(w, statusCode, err.Error())
 			return
 		}
-		ctx := context.WithValue(r.Context(), UserId, userId)
+		ctx := context.WithValue(r.Context(), UserId, caller.userId)
+		ctx = context.WithValue(ctx, Role, caller.role)
+		ctx = context.WithValue(ctx, SessionId, caller.sessionId)
+		ctx = context.WithValue(ctx, Scopes, caller.scopes)
 
 		// Call the next handler with the modified request context
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -93,6 +230,34 @@ func (cfg *apiConfig) CheckPolkaKey(next http.Handler) http.Handler {
 	})
 }
 
+// Authenticates the request and only lets through users with at least the
+// given role. The role is checked against the database rather than the token's
+// claim so demoting a user takes effect immediately.
+func (cfg *apiConfig) RequireRole(role db.Role, next http.Handler) http.Handler {
+	return cfg.EnsureAuthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, err := GetUserId(r)
+		if err != nil {
+			util.RespondWithError(w, 401, err.Error())
+			return
+		}
+		database, ok := cfg.db.GetDatabase()
+		if !ok {
+			util.RespondWithError(w, 500, "could not read from database")
+			return
+		}
+		user, exists := database.IDUsersMap[userId]
+		if !exists {
+			util.RespondWithError(w, 401, "user no longer exists")
+			return
+		}
+		if !user.GetRole().Includes(role) {
+			util.RespondWithError(w, 403, fmt.Sprintf("%s role required", role))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
 func (cfg *apiConfig) HandleMetrics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -104,9 +269,12 @@ func (cfg *apiConfig) HandleMetrics(w http.ResponseWriter, r *http.Request) {
 		<body>
 			<h1>Welcome, Chirpy Admin</h1>
 			<p>Chirpy has been visited %d times!</p>
+			<p>%d expired chirps have been purged!</p>
+			<p>%d trashed chirps have been purged!</p>
+			<p>%d expired sessions have been purged!</p>
 		</body>
 		</html>
-	`, cfg.fileserverHits)
+	`, cfg.fileserverHits, cfg.db.GetPurgedChirps(), cfg.db.GetPurgedTrash(), cfg.db.GetPurgedSessions())
 	cfg.mu.Unlock()
 	w.Header().Set("Content-Type", "text/html")
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,142 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Algorithm string
+
+const (
+	Bcrypt   Algorithm = "bcrypt"
+	Argon2id Algorithm = "argon2id"
+)
+
+type Argon2Params struct {
+	// In KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Hashes new passwords with the configured algorithm and parameters. Stored
+// hashes record their own, so older ones keep verifying and NeedsRehash
+// tells when one should be replaced.
+type Hasher struct {
+	Algorithm  Algorithm
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+const DefaultBcryptCost = bcrypt.DefaultCost
+
+// Parameters recommended by RFC 9106 for memory constrained machines
+var DefaultArgon2 = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func (hasher Hasher) Validate() error {
+	switch hasher.Algorithm {
+	case Bcrypt:
+		if hasher.BcryptCost < bcrypt.MinCost || hasher.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case Argon2id:
+		params := hasher.Argon2
+		if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 || params.SaltLength == 0 || params.KeyLength == 0 {
+			return fmt.Errorf("argon2id parameters must all be positive")
+		}
+	default:
+		return fmt.Errorf("unknown password hashing algorithm %q", hasher.Algorithm)
+	}
+	return nil
+}
+
+func (hasher Hasher) Hash(password string) ([]byte, error) {
+	if hasher.Algorithm == Argon2id {
+		return hashArgon2id(password, hasher.Argon2)
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), hasher.BcryptCost)
+}
+
+// Checks the password against a hash made with any supported algorithm
+func (hasher Hasher) Verify(hash []byte, password string) bool {
+	if strings.HasPrefix(string(hash), "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(string(hash))
+		if err != nil {
+			return false
+		}
+		candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		return subtle.ConstantTimeCompare(candidate, key) == 1
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// Whether the hash was made with another algorithm or other parameters than new hashes would be
+func (hasher Hasher) NeedsRehash(hash []byte) bool {
+	switch hasher.Algorithm {
+	case Argon2id:
+		params, salt, key, err := decodeArgon2id(string(hash))
+		if err != nil {
+			return true
+		}
+		params.SaltLength = uint32(len(salt))
+		params.KeyLength = uint32(len(key))
+		return params != hasher.Argon2
+	default:
+		cost, err := bcrypt.Cost(hash)
+		return err != nil || cost != hasher.BcryptCost
+	}
+}
+
+var encoding = base64.RawStdEncoding
+
+// Encoded in the PHC string format the reference implementation uses:
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+func hashArgon2id(password string, params Argon2Params) ([]byte, error) {
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Iterations, params.Parallelism, encoding.EncodeToString(salt), encoding.EncodeToString(key))
+	return []byte(encoded), nil
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2Params{}, nil, nil, fmt.Errorf("not an argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("unsupported argon2 version")
+	}
+	params := Argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id parameters")
+	}
+	salt, err := encoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	key, err := encoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
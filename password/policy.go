@@ -0,0 +1,35 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type Policy struct {
+	MinLength        int
+	RequireDigit     bool
+	RequireSymbol    bool
+	RequireMixedCase bool
+}
+
+// Returns an error describing the first rule the password breaks
+func (policy Policy) Validate(password string) error {
+	if len(password) < policy.MinLength {
+		return fmt.Errorf("password must be at least %d characters", policy.MinLength)
+	}
+	if policy.RequireDigit && !strings.ContainsFunc(password, unicode.IsDigit) {
+		return fmt.Errorf("password must contain a digit")
+	}
+	if policy.RequireSymbol && !strings.ContainsFunc(password, isSymbol) {
+		return fmt.Errorf("password must contain a symbol")
+	}
+	if policy.RequireMixedCase && (!strings.ContainsFunc(password, unicode.IsUpper) || !strings.ContainsFunc(password, unicode.IsLower)) {
+		return fmt.Errorf("password must contain upper and lower case letters")
+	}
+	return nil
+}
+
+func isSymbol(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
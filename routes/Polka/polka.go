@@ -3,6 +3,7 @@ package polka
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/tade3910/chirpy/db"
 	"github.com/tade3910/chirpy/util"
@@ -18,19 +19,17 @@ func GetPolkaHandler(db *db.Db) *polkaHandler {
 	}
 }
 
-func (handler *polkaHandler) upgradeUser(user_id int) (int, error) {
-	datbase, ok := handler.db.GetDatabase()
-	if !ok {
-		return 500, fmt.Errorf("error getting database")
-
-	}
-	user, exists := datbase.IDUsersMap[user_id]
+func upgradeUser(database *db.Database, user_id int) (int, error) {
+	user, exists := database.IDUsersMap[user_id]
 	if !exists {
 		return 404, fmt.Errorf("user with provided id doesn't exist")
 	}
 	user.Is_chirpy_red = true
-	datbase.Users[user.Email].Is_chirpy_red = true
-	handler.db.UpdateDatabase(datbase, db.NoDatabase)
+	user.Subscriptions = append(user.Subscriptions, db.SubscriptionEvent{
+		Event: "user.upgraded",
+		At:    time.Now().UTC(),
+	})
+	database.PutUser(user)
 	return 204, nil
 }
 
@@ -47,7 +46,9 @@ func (handler *polkaHandler) handlePost(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	if bodyStruct.Event == "user.upgraded" {
-		status, err := handler.upgradeUser(bodyStruct.Data.User_id)
+		status, err := handler.db.Apply(func(database *db.Database) (int, error) {
+			return upgradeUser(database, bodyStruct.Data.User_id)
+		})
 		if err != nil {
 			util.RespondWithError(w, status, err.Error())
 			return
@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/util"
+)
+
+type chirpHandler struct {
+	db *db.Db
+}
+
+func GetChirpHandler(db *db.Db) *chirpHandler {
+	return &chirpHandler{
+		db: db,
+	}
+}
+
+// Moderators can only act on chirps of users below their role, same as suspensions
+func checkOutranksAuthor(database *db.Database, moderatorId int, chirp db.Chirp) (int, error) {
+	moderator, exists := database.IDUsersMap[moderatorId]
+	if !exists {
+		return 401, fmt.Errorf("user no longer exists")
+	}
+	author, exists := database.IDUsersMap[chirp.AuthorId]
+	if !exists {
+		return 200, nil
+	}
+	if !moderator.GetRole().Outranks(author.GetRole()) {
+		return 403, fmt.Errorf("you can only moderate chirps of users below your role")
+	}
+	return 200, nil
+}
+
+// Permanently removes a chirp, skipping the author's trash
+func hardDeleteChirp(database *db.Database, moderatorId int, chirpId int, reportId *int) (int, error) {
+	chirp, ok := database.Chirps[chirpId]
+	if !ok {
+		return 404, fmt.Errorf("chirp with id %d doesn't exist in database", chirpId)
+	}
+	if statusCode, err := checkOutranksAuthor(database, moderatorId, chirp); err != nil {
+		return statusCode, err
+	}
+	delete(database.Chirps, chirpId)
+	database.Audit(db.AuditEntry{
+		ModeratorId: moderatorId,
+		Action:      "delete_chirp",
+		TargetType:  db.ChirpTarget,
+		TargetId:    chirpId,
+		ReportId:    reportId,
+		Details:     chirp.Body,
+	})
+	return 204, nil
+}
+
+func (handler *chirpHandler) deleteChirp(moderatorId int, chirpId int) (int, error) {
+	return handler.db.Apply(func(database *db.Database) (int, error) {
+		return hardDeleteChirp(database, moderatorId, chirpId, nil)
+	})
+}
+
+func (handler *chirpHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	chirpId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest, "id must be an int")
+		return
+	}
+	moderatorId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	statusCode, err := handler.deleteChirp(moderatorId, chirpId)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, nil)
+}
+
+func (handler *chirpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodDelete:
+		handler.handleDelete(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
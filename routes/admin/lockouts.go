@@ -0,0 +1,105 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/util"
+)
+
+type lockoutInfo struct {
+	Kind        string
+	Subject     string
+	Failures    int
+	LastFailure time.Time
+	Locked      bool
+	LockedUntil *time.Time `json:",omitempty"`
+}
+
+type lockoutsHandler struct {
+	db       *db.Db
+	lockouts *db.Lockouts
+}
+
+func GetLockoutsHandler(db *db.Db, lockouts *db.Lockouts) *lockoutsHandler {
+	return &lockoutsHandler{
+		db:       db,
+		lockouts: lockouts,
+	}
+}
+
+func (handler *lockoutsHandler) handleGet(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	lockouts := []lockoutInfo{}
+	for key, failures := range handler.lockouts.GetFailures(now) {
+		kind, subject, _ := strings.Cut(key, ":")
+		lockouts = append(lockouts, lockoutInfo{
+			Kind:        kind,
+			Subject:     subject,
+			Failures:    failures.Count,
+			LastFailure: failures.LastFailure,
+			Locked:      failures.IsLocked(now),
+			LockedUntil: failures.LockedUntil,
+		})
+	}
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].LastFailure.After(lockouts[j].LastFailure)
+	})
+	util.RespondWithJSON(w, 200, lockouts)
+}
+
+func (handler *lockoutsHandler) clearLockout(adminId int, kind string, subject string) (int, error) {
+	var key string
+	switch kind {
+	case "account":
+		key = db.AccountLockoutKey(subject)
+	case "ip":
+		key = db.IpLockoutKey(subject)
+	default:
+		return 400, fmt.Errorf("kind must be account or ip")
+	}
+	if !handler.lockouts.Clear(key) {
+		return 404, fmt.Errorf("no failed logins recorded for %s %s", kind, subject)
+	}
+	if !handler.db.Update(func(database *db.Database) bool {
+		database.LogSecurityEvent(db.SecurityEvent{
+			Type:    db.LockoutCleared,
+			UserId:  adminId,
+			Details: fmt.Sprintf("failed logins for %s %s cleared", kind, subject),
+		})
+		return true
+	}) {
+		return 500, fmt.Errorf("could not update database")
+	}
+	return 204, nil
+}
+
+func (handler *lockoutsHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	adminId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	statusCode, err := handler.clearLockout(adminId, r.PathValue("kind"), r.PathValue("subject"))
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, nil)
+}
+
+func (handler *lockoutsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.PathValue("kind") == "":
+		handler.handleGet(w, r)
+	case r.Method == http.MethodDelete && r.PathValue("kind") != "":
+		handler.handleDelete(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,239 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/util"
+)
+
+type reportsHandler struct {
+	db *db.Db
+}
+
+func GetReportsHandler(db *db.Db) *reportsHandler {
+	return &reportsHandler{
+		db: db,
+	}
+}
+
+const (
+	dismiss       = "dismiss"
+	hideChirp     = "hide_chirp"
+	deleteChirp   = "delete_chirp"
+	suspendAuthor = "suspend_author"
+)
+
+// Suspension fields are only read for suspend_author
+type resolution struct {
+	Action string
+	Note   string
+	suspensionRequest
+}
+
+// Reports can be worked on by whoever claimed them, or anyone while unclaimed
+func checkClaim(report db.Report, moderatorId int) (int, error) {
+	if report.Status == db.ReportResolved {
+		return 409, fmt.Errorf("report %d is already resolved", report.Id)
+	}
+	if report.ClaimedBy != nil && *report.ClaimedBy != moderatorId {
+		return 409, fmt.Errorf("report %d is claimed by another moderator", report.Id)
+	}
+	return 200, nil
+}
+
+func claimReport(database *db.Database, moderatorId int, reportId int) (db.Report, int, error) {
+	report, exists := database.Reports[reportId]
+	if !exists {
+		return db.Report{}, 404, fmt.Errorf("report %d doesn't exist", reportId)
+	}
+	if statusCode, err := checkClaim(report, moderatorId); err != nil {
+		return db.Report{}, statusCode, err
+	}
+	report.Status = db.ReportClaimed
+	report.ClaimedBy = &moderatorId
+	database.Reports[reportId] = report
+	database.Audit(db.AuditEntry{
+		ModeratorId: moderatorId,
+		Action:      "claim_report",
+		TargetType:  report.TargetType,
+		TargetId:    report.TargetId,
+		ReportId:    &report.Id,
+	})
+	return report, 200, nil
+}
+
+// Carries out the resolution's action on what the report is about
+func applyResolution(database *db.Database, moderatorId int, report db.Report, resolution *resolution) (int, error) {
+	if (resolution.Action == hideChirp || resolution.Action == deleteChirp) && report.TargetType != db.ChirpTarget {
+		return 400, fmt.Errorf("%s only applies to chirp reports", resolution.Action)
+	}
+	switch resolution.Action {
+	case dismiss:
+		return 200, nil
+	case hideChirp:
+		chirp, exists := database.Chirps[report.TargetId]
+		if !exists {
+			return 404, fmt.Errorf("chirp with id %d doesn't exist in database", report.TargetId)
+		}
+		if statusCode, err := checkOutranksAuthor(database, moderatorId, chirp); err != nil {
+			return statusCode, err
+		}
+		chirp.Hidden = true
+		database.Chirps[report.TargetId] = chirp
+		database.Audit(db.AuditEntry{
+			ModeratorId: moderatorId,
+			Action:      hideChirp,
+			TargetType:  db.ChirpTarget,
+			TargetId:    report.TargetId,
+			ReportId:    &report.Id,
+		})
+		return 200, nil
+	case deleteChirp:
+		return hardDeleteChirp(database, moderatorId, report.TargetId, &report.Id)
+	case suspendAuthor:
+		if err := resolution.validate(); err != nil {
+			return 400, err
+		}
+		authorId := report.TargetId
+		if report.TargetType == db.ChirpTarget {
+			chirp, exists := database.Chirps[report.TargetId]
+			if !exists {
+				return 404, fmt.Errorf("chirp with id %d doesn't exist in database", report.TargetId)
+			}
+			authorId = chirp.AuthorId
+		}
+		return suspendUser(database, moderatorId, authorId, resolution.toSuspension(moderatorId))
+	default:
+		return 400, fmt.Errorf("action must be one of %s, %s, %s or %s", dismiss, hideChirp, deleteChirp, suspendAuthor)
+	}
+}
+
+func resolveReport(database *db.Database, moderatorId int, reportId int, resolution *resolution) (db.Report, int, error) {
+	report, exists := database.Reports[reportId]
+	if !exists {
+		return db.Report{}, 404, fmt.Errorf("report %d doesn't exist", reportId)
+	}
+	if statusCode, err := checkClaim(report, moderatorId); err != nil {
+		return db.Report{}, statusCode, err
+	}
+	if statusCode, err := applyResolution(database, moderatorId, report, resolution); err != nil {
+		return db.Report{}, statusCode, err
+	}
+	now := time.Now().UTC()
+	report.Status = db.ReportResolved
+	report.ClaimedBy = &moderatorId
+	report.ResolvedAt = &now
+	report.Resolution = resolution.Action
+	database.Reports[reportId] = report
+	database.Audit(db.AuditEntry{
+		ModeratorId: moderatorId,
+		Action:      "resolve_report",
+		TargetType:  report.TargetType,
+		TargetId:    report.TargetId,
+		ReportId:    &report.Id,
+		Details:     fmt.Sprintf("%s: %s", resolution.Action, resolution.Note),
+	})
+	return report, 200, nil
+}
+
+// Runs a claim or resolution with everything it reads and writes under the database lock
+func (handler *reportsHandler) updateReport(change func(*db.Database) (db.Report, int, error)) (db.Report, int, error) {
+	var report db.Report
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		var statusCode int
+		var err error
+		report, statusCode, err = change(database)
+		return statusCode, err
+	})
+	if err != nil {
+		return db.Report{}, statusCode, err
+	}
+	return report, statusCode, nil
+}
+
+func (handler *reportsHandler) handleList(w http.ResponseWriter, r *http.Request) {
+	database, success := handler.db.GetDatabase()
+	if !success {
+		util.RespondWithError(w, http.StatusInternalServerError, "Couldn't read from database")
+		return
+	}
+	util.RespondWithJSON(w, 200, database.GetReports(db.ReportStatus(r.URL.Query().Get("status"))))
+}
+
+func (handler *reportsHandler) handleAction(w http.ResponseWriter, r *http.Request) {
+	moderatorId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	reportId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest, "id must be an int")
+		return
+	}
+	var report db.Report
+	var statusCode int
+	switch r.PathValue("action") {
+	case "claim":
+		report, statusCode, err = handler.updateReport(func(database *db.Database) (db.Report, int, error) {
+			return claimReport(database, moderatorId, reportId)
+		})
+	case "resolve":
+		resolution, ok := util.GetBody(r, &resolution{})
+		if !ok {
+			util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+			return
+		}
+		report, statusCode, err = handler.updateReport(func(database *db.Database) (db.Report, int, error) {
+			return resolveReport(database, moderatorId, reportId, resolution)
+		})
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, report)
+}
+
+// Handles GET /admin/reports and POST /admin/reports/{id}/claim or /resolve
+func (handler *reportsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.PathValue("id") == "":
+		handler.handleList(w, r)
+	case r.Method == http.MethodPost && r.PathValue("id") != "":
+		handler.handleAction(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type auditHandler struct {
+	db *db.Db
+}
+
+func GetAuditHandler(db *db.Db) *auditHandler {
+	return &auditHandler{
+		db: db,
+	}
+}
+
+func (handler *auditHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	database, success := handler.db.GetDatabase()
+	if !success {
+		util.RespondWithError(w, http.StatusInternalServerError, "Couldn't read from database")
+		return
+	}
+	util.RespondWithJSON(w, 200, database.AuditLog)
+}
@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/util"
+)
+
+type securityHandler struct {
+	db *db.Db
+}
+
+func GetSecurityHandler(db *db.Db) *securityHandler {
+	return &securityHandler{
+		db: db,
+	}
+}
+
+func (handler *securityHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	database, success := handler.db.GetDatabase()
+	if !success {
+		util.RespondWithError(w, http.StatusInternalServerError, "Couldn't read from database")
+		return
+	}
+	util.RespondWithJSON(w, 200, database.SecurityEvents)
+}
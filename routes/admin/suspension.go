@@ -0,0 +1,138 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/util"
+)
+
+type suspensionHandler struct {
+	db *db.Db
+}
+
+func GetSuspensionHandler(db *db.Db) *suspensionHandler {
+	return &suspensionHandler{
+		db: db,
+	}
+}
+
+type suspensionRequest struct {
+	Reason           string
+	Duration_seconds int
+	Permanent        bool
+	HideChirps       bool
+}
+
+func (request *suspensionRequest) validate() error {
+	if request.Reason == "" {
+		return fmt.Errorf("a reason is required")
+	}
+	if !request.Permanent && request.Duration_seconds <= 0 {
+		return fmt.Errorf("a positive duration_seconds is required unless the ban is permanent")
+	}
+	return nil
+}
+
+func (request *suspensionRequest) toSuspension(moderatorId int) *db.Suspension {
+	now := time.Now().UTC()
+	suspension := &db.Suspension{
+		Reason:      request.Reason,
+		ModeratorId: moderatorId,
+		CreatedAt:   now,
+		HideChirps:  request.HideChirps,
+	}
+	if !request.Permanent {
+		until := now.Add(time.Duration(request.Duration_seconds) * time.Second)
+		suspension.Until = &until
+	}
+	return suspension
+}
+
+// Sets or lifts a suspension if the moderator outranks the user, a nil suspension lifts it
+func suspendUser(database *db.Database, moderatorId int, userId int, suspension *db.Suspension) (int, error) {
+	moderator, exists := database.IDUsersMap[moderatorId]
+	if !exists {
+		return 401, fmt.Errorf("user no longer exists")
+	}
+	user, exists := database.IDUsersMap[userId]
+	if !exists {
+		return 404, fmt.Errorf("user doesn't exist")
+	}
+	if !moderator.GetRole().Outranks(user.GetRole()) {
+		return 403, fmt.Errorf("you can only moderate users below your role")
+	}
+	user.Suspension = suspension
+	database.PutUser(user)
+	entry := db.AuditEntry{
+		ModeratorId: moderatorId,
+		Action:      "unsuspend",
+		TargetType:  db.UserTarget,
+		TargetId:    userId,
+	}
+	if suspension != nil {
+		entry.Action = "suspend"
+		entry.Details = suspension.Error().Error()
+	}
+	database.Audit(entry)
+	return 204, nil
+}
+
+func (handler *suspensionHandler) updateSuspension(moderatorId int, userId int, suspension *db.Suspension) (int, error) {
+	return handler.db.Apply(func(database *db.Database) (int, error) {
+		return suspendUser(database, moderatorId, userId, suspension)
+	})
+}
+
+func (handler *suspensionHandler) handlePost(w http.ResponseWriter, r *http.Request, moderatorId int, userId int) {
+	request, ok := util.GetBody(r, &suspensionRequest{})
+	if !ok {
+		util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+		return
+	}
+	if err := request.validate(); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	statusCode, err := handler.updateSuspension(moderatorId, userId, request.toSuspension(moderatorId))
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, nil)
+}
+
+func (handler *suspensionHandler) handleDelete(w http.ResponseWriter, moderatorId int, userId int) {
+	statusCode, err := handler.updateSuspension(moderatorId, userId, nil)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, nil)
+}
+
+// Handles POST and DELETE on /admin/users/{id}/suspension
+func (handler *suspensionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	moderatorId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	userId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest, "id must be an int")
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		handler.handlePost(w, r, moderatorId, userId)
+	case http.MethodDelete:
+		handler.handleDelete(w, moderatorId, userId)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/util"
+)
+
+type roleHandler struct {
+	db *db.Db
+}
+
+func GetRoleHandler(db *db.Db) *roleHandler {
+	return &roleHandler{
+		db: db,
+	}
+}
+
+func (handler *roleHandler) setRole(adminId int, userId int, role db.Role) (db.PlainUser, int, error) {
+	if !role.IsValid() {
+		return db.PlainUser{}, 400, fmt.Errorf("unknown role %s", role)
+	}
+	var updated db.PlainUser
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		user, exists := database.IDUsersMap[userId]
+		if !exists {
+			return 404, fmt.Errorf("user doesn't exist")
+		}
+		user.Role = role
+		database.PutUser(user)
+		// Never leave chirpy without someone who can hand out roles
+		if !database.HasAdmin() {
+			return 409, fmt.Errorf("can't remove the last admin")
+		}
+		database.Audit(db.AuditEntry{
+			ModeratorId: adminId,
+			Action:      "set_role",
+			TargetType:  db.UserTarget,
+			TargetId:    userId,
+			Details:     string(role),
+		})
+		updated = user.PlainUser
+		return 200, nil
+	})
+	if err != nil {
+		return db.PlainUser{}, statusCode, err
+	}
+	return updated, statusCode, nil
+}
+
+// Handles PUT /admin/users/{id}/role
+func (handler *roleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	adminId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	userId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest, "id must be an int")
+		return
+	}
+	body, ok := util.GetBody(r, &struct{ Role db.Role }{})
+	if !ok {
+		util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+		return
+	}
+	response, statusCode, err := handler.setRole(adminId, userId, body.Role)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, response)
+}
@@ -0,0 +1,154 @@
+package apikeys
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/util"
+)
+
+const (
+	maxNameLength  = 50
+	maxKeysPerUser = 25
+)
+
+type keyRequest struct {
+	Name               string
+	Scopes             []db.Scope
+	Expires_in_seconds int
+}
+
+func (request *keyRequest) validate() error {
+	if request.Name == "" || len(request.Name) > maxNameLength {
+		return fmt.Errorf("name must be between 1 and %d characters", maxNameLength)
+	}
+	if len(request.Scopes) == 0 {
+		return fmt.Errorf("at least one scope is required, choose from %v", db.Scopes)
+	}
+	for _, scope := range request.Scopes {
+		if !scope.IsValid() {
+			return fmt.Errorf("unknown scope %q, choose from %v", scope, db.Scopes)
+		}
+	}
+	if request.Expires_in_seconds < 0 {
+		return fmt.Errorf("Expires_in_seconds can't be negative")
+	}
+	return nil
+}
+
+// The secret is only ever in the response that creates the key
+type createdKey struct {
+	Key string
+	db.ApiKey
+}
+
+type apiKeysHandler struct {
+	db *db.Db
+}
+
+func GetApiKeysHandler(db *db.Db) *apiKeysHandler {
+	return &apiKeysHandler{
+		db: db,
+	}
+}
+
+func (handler *apiKeysHandler) createKey(userId int, request *keyRequest) (createdKey, int, error) {
+	if err := request.validate(); err != nil {
+		return createdKey{}, 400, err
+	}
+	var created createdKey
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		if len(database.GetUserApiKeys(userId)) >= maxKeysPerUser {
+			return 409, fmt.Errorf("you can have at most %d API keys, revoke one first", maxKeysPerUser)
+		}
+		id, err := util.CreateRandomString(8)
+		if err != nil {
+			return 500, fmt.Errorf("could not create API key")
+		}
+		secret, err := util.CreateRandomString(32)
+		if err != nil {
+			return 500, fmt.Errorf("could not create API key")
+		}
+		key := db.ApiKey{
+			Id:        id,
+			UserId:    userId,
+			Name:      request.Name,
+			Scopes:    request.Scopes,
+			CreatedAt: time.Now().UTC(),
+		}
+		if request.Expires_in_seconds > 0 {
+			expires := key.CreatedAt.Add(time.Duration(request.Expires_in_seconds) * time.Second)
+			key.Expires = &expires
+		}
+		database.PutApiKey(secret, key)
+		key, _ = database.GetApiKey(secret)
+		created = createdKey{Key: secret, ApiKey: key}
+		return 201, nil
+	})
+	if err != nil {
+		return createdKey{}, statusCode, err
+	}
+	return created, statusCode, nil
+}
+
+func (handler *apiKeysHandler) revokeKey(userId int, id string) (int, error) {
+	return handler.db.Apply(func(database *db.Database) (int, error) {
+		if !database.RevokeApiKey(userId, id) {
+			return 404, fmt.Errorf("API key doesn't exist")
+		}
+		return 204, nil
+	})
+}
+
+func (handler *apiKeysHandler) handleGet(w http.ResponseWriter, userId int) {
+	database, success := handler.db.GetDatabase()
+	if !success {
+		util.RespondWithError(w, http.StatusInternalServerError, "Couldn't read from database")
+		return
+	}
+	util.RespondWithJSON(w, 200, database.GetUserApiKeys(userId))
+}
+
+func (handler *apiKeysHandler) handlePost(w http.ResponseWriter, r *http.Request, userId int) {
+	request, ok := util.GetBody(r, &keyRequest{})
+	if !ok {
+		util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+		return
+	}
+	key, statusCode, err := handler.createKey(userId, request)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, key)
+}
+
+func (handler *apiKeysHandler) handleDelete(w http.ResponseWriter, r *http.Request, userId int) {
+	statusCode, err := handler.revokeKey(userId, r.PathValue("id"))
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, nil)
+}
+
+func (handler *apiKeysHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.PathValue("id") == "":
+		handler.handleGet(w, userId)
+	case r.Method == http.MethodPost && r.PathValue("id") == "":
+		handler.handlePost(w, r, userId)
+	case r.Method == http.MethodDelete && r.PathValue("id") != "":
+		handler.handleDelete(w, r, userId)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
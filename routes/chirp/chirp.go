@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tade3910/chirpy/db"
 	"github.com/tade3910/chirpy/middleware/apiConfig"
@@ -21,33 +22,33 @@ func GetChirpHandler(db *db.Db) *chirpHandler {
 	}
 }
 
-func (handler *chirpHandler) getChirp(chripId int) (db.Chirp, bool) {
+func (handler *chirpHandler) getChirp(chripId int, viewerId int) (db.Chirp, bool) {
 	readDatabase, success := handler.db.GetDatabase()
 	if !success {
 		return db.Chirp{}, false
 	}
 	chirp, ok := readDatabase.Chirps[chripId]
-	if !ok {
+	if !ok || readDatabase.IsChirpHiddenFrom(viewerId, chirp, time.Now().UTC()) {
 		return db.Chirp{}, false
 	}
 	return chirp, true
 }
 
 func (handler *chirpHandler) deleteChirp(chripId int, auhtorId int) (int, error) {
-	readDatabase, success := handler.db.GetDatabase()
-	if !success {
-		return 500, fmt.Errorf("could not read from database")
-	}
-	chirp, ok := readDatabase.Chirps[chripId]
-	if !ok {
-		return 500, fmt.Errorf("chirp with id %d doesn't exist in database", chripId)
-	}
-	if chirp.AuthorId != auhtorId {
-		return 403, fmt.Errorf("user does not have delete access to this chirp")
-	}
-	delete(readDatabase.Chirps, chripId)
-	handler.db.UpdateDatabase(readDatabase, db.NoDatabase)
-	return 204, nil
+	return handler.db.Apply(func(readDatabase *db.Database) (int, error) {
+		chirp, ok := readDatabase.Chirps[chripId]
+		now := time.Now().UTC()
+		if !ok || !chirp.IsVisible(now) {
+			return 404, fmt.Errorf("chirp with id %d doesn't exist in database", chripId)
+		}
+		if chirp.AuthorId != auhtorId {
+			return 403, fmt.Errorf("user does not have delete access to this chirp")
+		}
+		// Deleted chirps go to the author's trash until the sweeper purges them
+		chirp.DeletedAt = &now
+		readDatabase.Chirps[chripId] = chirp
+		return 204, nil
+	})
 }
 
 func (handler *chirpHandler) handleGetParamsId(r *http.Request) (int, error) {
@@ -93,7 +94,12 @@ func (handler *chirpHandler) handleGet(w http.ResponseWriter, r *http.Request) {
 		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	chirp, ok := handler.getChirp(chripId)
+	viewerId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	chirp, ok := handler.getChirp(chripId, viewerId)
 	if !ok {
 		util.RespondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Could not get chrip with id, %d", chripId))
 		return
@@ -0,0 +1,73 @@
+package chirp
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/util"
+)
+
+type restoreHandler struct {
+	db *db.Db
+}
+
+func GetRestoreHandler(db *db.Db) *restoreHandler {
+	return &restoreHandler{
+		db: db,
+	}
+}
+
+func (handler *restoreHandler) restoreChirp(chirpId int, authorId int) (db.Chirp, int, error) {
+	var restored db.Chirp
+	statusCode, err := handler.db.Apply(func(readDatabase *db.Database) (int, error) {
+		now := time.Now().UTC()
+		chirp, ok := readDatabase.Chirps[chirpId]
+		if !ok || chirp.AuthorId != authorId || !chirp.IsInTrash(now, handler.db.GetTrashRetention()) {
+			return 404, fmt.Errorf("chirp with id %d is not in your trash", chirpId)
+		}
+		// Restoring would leave it invisible until the sweeper purges it
+		if chirp.IsExpired(now) {
+			return 410, fmt.Errorf("chirp with id %d has expired and can't be restored", chirpId)
+		}
+		chirp.DeletedAt = nil
+		readDatabase.Chirps[chirpId] = chirp
+		restored = chirp
+		return 200, nil
+	})
+	if err != nil {
+		return db.Chirp{}, statusCode, err
+	}
+	return restored, statusCode, nil
+}
+
+func (handler *restoreHandler) handlePost(w http.ResponseWriter, r *http.Request) {
+	chirpId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest, "id must be an int")
+		return
+	}
+	authorId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	chirp, statusCode, err := handler.restoreChirp(chirpId, authorId)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, chirp)
+}
+
+func (handler *restoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		handler.handlePost(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
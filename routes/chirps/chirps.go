@@ -3,8 +3,11 @@ package chirps
 import (
 	"fmt"
 	"net/http"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tade3910/chirpy/db"
 	"github.com/tade3910/chirpy/middleware/apiConfig"
@@ -31,17 +34,17 @@ func (handler *chirpsHandler) handlePost(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		util.RespondWithError(w, http.StatusInternalServerError, "error converting id to int")
 	}
-	chrip, ok := handleChirp(r)
+	chrip, ttl, ok := handleChirp(r)
 	if !ok {
 		util.RespondWithError(w, http.StatusInternalServerError, "Invalid chirp posted")
 		return
 	}
-	response, ok := handler.updateChirps(chrip, authorId)
-	if !ok {
-		util.RespondWithError(w, http.StatusInternalServerError, "Couldn't update database")
+	response, statusCode, err := handler.updateChirps(chrip, authorId, ttl)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
 		return
 	}
-	util.RespondWithJSON(w, 200, response)
+	util.RespondWithJSON(w, statusCode, response)
 }
 
 func cleanBody(s string) string {
@@ -55,35 +58,50 @@ func cleanBody(s string) string {
 	return strings.Join(words, " ")
 }
 
-func handleChirp(r *http.Request) (string, bool) {
+// Returns the cleaned chirp body and its time to live, 0 meaning it never expires
+func handleChirp(r *http.Request) (string, time.Duration, bool) {
 	type respBody struct {
-		Body string
+		Body        string
+		Ttl_seconds int
 	}
 	bodyStruct, ok := util.GetBody(r, &respBody{})
 	if !ok {
-		return "", false
+		return "", 0, false
 	}
-	if len(bodyStruct.Body) > 140 {
-		return "", false
+	if len(bodyStruct.Body) > 140 || bodyStruct.Ttl_seconds < 0 {
+		return "", 0, false
 	} else {
-		return cleanBody(bodyStruct.Body), true
+		return cleanBody(bodyStruct.Body), time.Duration(bodyStruct.Ttl_seconds) * time.Second, true
 	}
 }
 
-func (handler *chirpsHandler) getFormatedDatabase() ([]db.Chirp, bool) {
+// Leaves out chirps the viewer shouldn't see, including those from blocked and muted users
+func (handler *chirpsHandler) getFormatedDatabase(viewerId int) ([]db.Chirp, bool) {
 	database, success := handler.db.GetDatabase()
 	if !success {
 		return nil, false
 	}
-	formatedDatabse := make([]db.Chirp, len(database.Chirps))
-	for key, chirp := range database.Chirps {
-		formatedDatabse[key] = chirp
+	now := time.Now().UTC()
+	formatedDatabse := make([]db.Chirp, 0, len(database.Chirps))
+	for _, chirp := range database.Chirps {
+		if database.IsChirpHiddenFrom(viewerId, chirp, now) {
+			continue
+		}
+		formatedDatabse = append(formatedDatabse, chirp)
 	}
+	sort.Slice(formatedDatabse, func(i, j int) bool {
+		return formatedDatabse[i].Id < formatedDatabse[j].Id
+	})
 	return formatedDatabse, true
 }
 
-func (handler *chirpsHandler) handleGet(w http.ResponseWriter) {
-	chirps, ok := handler.getFormatedDatabase()
+func (handler *chirpsHandler) handleGet(w http.ResponseWriter, r *http.Request) {
+	viewerId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	chirps, ok := handler.getFormatedDatabase(viewerId)
 	if !ok {
 		util.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
 		return
@@ -94,7 +112,7 @@ func (handler *chirpsHandler) handleGet(w http.ResponseWriter) {
 func (handler *chirpsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		handler.handleGet(w)
+		handler.handleGet(w, r)
 	case http.MethodPost:
 		handler.handlePost(w, r)
 	default:
@@ -102,23 +120,41 @@ func (handler *chirpsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-func (handler *chirpsHandler) updateChirps(data string, authorId int) (db.Chirp, bool) {
-	database, success := handler.db.GetDatabase()
-	if !success {
-		fmt.Println("Problem getting database")
-		return db.Chirp{}, false
-	}
-	id := handler.db.GetNextId()
-	nextChirp := db.Chirp{
-		Id:       id,
-		Body:     data,
-		AuthorId: authorId,
+var mentionPattern = regexp.MustCompile(`@([a-z0-9_]{3,15})`)
+
+// Users can't mention anyone they have a block with in either direction
+func checkMentions(database *db.Database, body string, authorId int) error {
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		mentionedId, exists := database.Handles[match[1]]
+		if exists && database.IsBlocked(authorId, mentionedId) {
+			return fmt.Errorf("you can't mention @%s", match[1])
+		}
 	}
-	database.Chirps[id] = nextChirp
-	success = handler.db.UpdateDatabase(database, db.ChirpDatabase)
-	if !success {
-		fmt.Println("Problem getting database")
-		return db.Chirp{}, false
+	return nil
+}
+
+func (handler *chirpsHandler) updateChirps(data string, authorId int, ttl time.Duration) (db.Chirp, int, error) {
+	var created db.Chirp
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		if err := checkMentions(database, data, authorId); err != nil {
+			return 403, err
+		}
+		id := database.NewChirpId()
+		nextChirp := db.Chirp{
+			Id:       id,
+			Body:     data,
+			AuthorId: authorId,
+		}
+		if ttl > 0 {
+			expires := time.Now().UTC().Add(ttl)
+			nextChirp.Expires = &expires
+		}
+		database.Chirps[id] = nextChirp
+		created = nextChirp
+		return 200, nil
+	})
+	if err != nil {
+		return db.Chirp{}, statusCode, err
 	}
-	return nextChirp, true
+	return created, statusCode, nil
 }
@@ -0,0 +1,124 @@
+package export
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/exporter"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/util"
+)
+
+type exportStatus struct {
+	Id          string
+	Status      db.ExportStatus
+	CreatedAt   time.Time
+	Expires     *time.Time `json:",omitempty"`
+	DownloadUrl string     `json:",omitempty"`
+}
+
+type exportHandler struct {
+	db       *db.Db
+	exporter *exporter.Exporter
+}
+
+func GetExportHandler(db *db.Db, exporter *exporter.Exporter) *exportHandler {
+	return &exportHandler{
+		db:       db,
+		exporter: exporter,
+	}
+}
+
+func (handler *exportHandler) handlePost(w http.ResponseWriter, r *http.Request) {
+	userId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	export, statusCode, err := handler.exporter.Enqueue(userId)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, exportStatus{
+		Id:        export.Id,
+		Status:    export.Status,
+		CreatedAt: export.CreatedAt,
+	})
+}
+
+// Each look at a ready export hands out a fresh download link
+func (handler *exportHandler) handleGet(w http.ResponseWriter, r *http.Request) {
+	userId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	database, ok := handler.db.GetDatabase()
+	if !ok {
+		util.RespondWithError(w, http.StatusInternalServerError, "Couldn't read from database")
+		return
+	}
+	export, exists := database.Exports[r.PathValue("id")]
+	if !exists || export.UserId != userId {
+		util.RespondWithError(w, http.StatusNotFound, "No such export exists")
+		return
+	}
+	response := exportStatus{
+		Id:        export.Id,
+		Status:    export.Status,
+		CreatedAt: export.CreatedAt,
+		Expires:   export.Expires,
+	}
+	if export.Status == db.ExportReady {
+		token, ok := handler.exporter.CreateDownloadToken(export.Id)
+		if !ok {
+			util.RespondWithError(w, http.StatusInternalServerError, "Couldn't create download link")
+			return
+		}
+		response.DownloadUrl = fmt.Sprintf("/api/exports/%s?token=%s", export.Id, url.QueryEscape(token))
+	}
+	util.RespondWithJSON(w, 200, response)
+}
+
+// Handles POST /api/users/me/export and GET /api/users/me/export/{id}
+func (handler *exportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.PathValue("id") == "":
+		handler.handlePost(w, r)
+	case r.Method == http.MethodGet && r.PathValue("id") != "":
+		handler.handleGet(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type downloadHandler struct {
+	exporter *exporter.Exporter
+}
+
+// Downloads are authorized by the link's token so they work straight from a browser
+func GetDownloadHandler(exporter *exporter.Exporter) *downloadHandler {
+	return &downloadHandler{
+		exporter: exporter,
+	}
+}
+
+func (handler *downloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	exportId := r.PathValue("id")
+	path, ok := handler.exporter.GetArchive(exportId, r.URL.Query().Get("token"))
+	if !ok {
+		util.RespondWithError(w, http.StatusNotFound, "Download link is invalid or has expired")
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%s.zip"`, exportId))
+	http.ServeFile(w, r, path)
+}
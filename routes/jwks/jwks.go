@@ -0,0 +1,27 @@
+package jwks
+
+import (
+	"net/http"
+
+	"github.com/tade3910/chirpy/signing"
+	"github.com/tade3910/chirpy/util"
+)
+
+type jwksHandler struct {
+	keys *signing.KeySet
+}
+
+func GetJwksHandler(keys *signing.KeySet) *jwksHandler {
+	return &jwksHandler{
+		keys: keys,
+	}
+}
+
+// Publishes the public keys so other services can verify tokens without the signing key
+func (handler *jwksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	util.RespondWithJSON(w, 200, handler.keys.Jwks())
+}
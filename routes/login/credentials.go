@@ -0,0 +1,102 @@
+package login
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/password"
+	"github.com/tade3910/chirpy/totp"
+)
+
+// Checks passwords and two factor codes for everything that logs users in,
+// so /api/login and the OAuth consent page share one lockout
+type CredentialChecker struct {
+	db       *db.Db
+	hasher   password.Hasher
+	lockouts *db.Lockouts
+	// Compared against when the email is unknown so it takes as long as a wrong password
+	dummyHash []byte
+}
+
+// Checks write to db themselves, so they must not be made from inside db.Update
+func GetCredentialChecker(db *db.Db, hasher password.Hasher, lockouts *db.Lockouts) *CredentialChecker {
+	dummyHash, _ := hasher.Hash("not a real password")
+	return &CredentialChecker{
+		db:        db,
+		hasher:    hasher,
+		lockouts:  lockouts,
+		dummyHash: dummyHash,
+	}
+}
+
+// Returns when logins can be retried if the email or the address is locked out
+func (checker *CredentialChecker) Locked(database *db.Database, email string, ip string, now time.Time) (time.Time, bool) {
+	return getLockout(checker.lockouts, email, ip, now)
+}
+
+// Returns the user if the password is right. Failures count towards the lockout.
+// Anything it changes is written by itself, under the database lock.
+func (checker *CredentialChecker) CheckPassword(database *db.Database, email string, pass string, ip string, now time.Time) (*db.User, bool) {
+	passwordHash := checker.dummyHash
+	user, exists := database.Users[email]
+	if exists {
+		passwordHash = user.Password
+	}
+	if !checker.hasher.Verify(passwordHash, pass) || !exists {
+		recordFailure(checker.db, checker.lockouts, checker.lockouts.Policy(), email, ip, now)
+		return nil, false
+	}
+	checker.lockouts.Clear(db.AccountLockoutKey(email))
+	// Logging in is the only time the password is known, so hashes from
+	// older settings are upgraded then
+	if checker.hasher.NeedsRehash(user.Password) {
+		if rehashed, err := checker.hasher.Hash(pass); err == nil {
+			checker.upgradeHash(user.Id, user.Password, rehashed)
+		}
+	}
+	return user, true
+}
+
+// Leaves the hash alone if the password was changed since it was checked
+func (checker *CredentialChecker) upgradeHash(userId int, checked []byte, rehashed []byte) {
+	checker.db.Update(func(database *db.Database) bool {
+		user, exists := database.IDUsersMap[userId]
+		if !exists || !bytes.Equal(user.Password, checked) {
+			return false
+		}
+		user.Password = rehashed
+		database.PutUser(user)
+		return true
+	})
+}
+
+// Accepts a code from the authenticator app or one of the recovery codes.
+// Wrong codes count towards the lockout too, otherwise knowing the password
+// would allow unlimited guesses. The code is checked and used up under the
+// database lock so it can't be accepted twice.
+func (checker *CredentialChecker) CheckTwoFactor(user *db.User, code string, recoveryCode string, ip string, now time.Time) bool {
+	accepted := false
+	checker.db.Update(func(database *db.Database) bool {
+		current, exists := database.IDUsersMap[user.Id]
+		if !exists || !current.HasTwoFactor() {
+			return false
+		}
+		if recoveryCode != "" {
+			accepted = current.TwoFactor.ConsumeRecoveryCode(recoveryCode)
+		} else if step, ok := totp.Validate(current.TwoFactor.Secret, code, now, current.TwoFactor.LastStep); ok {
+			current.TwoFactor.LastStep = step
+			accepted = true
+		}
+		if accepted {
+			database.PutUser(current)
+		}
+		return accepted
+	})
+	if !accepted {
+		recordFailure(checker.db, checker.lockouts, checker.lockouts.Policy(), user.Email, ip, now)
+		return false
+	}
+	checker.lockouts.Clear(db.AccountLockoutKey(user.Email))
+	return true
+}
@@ -0,0 +1,58 @@
+package login
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/util"
+)
+
+// The same for unknown emails and wrong passwords so logins can't be used to find accounts
+const invalidCredentials = "incorrect email or password"
+
+// Returns when the login can be retried if the email or the address is locked out
+func getLockout(lockouts *db.Lockouts, email string, ip string, now time.Time) (time.Time, bool) {
+	accountUntil, accountLocked := lockouts.GetLockout(db.AccountLockoutKey(email), now)
+	ipUntil, ipLocked := lockouts.GetLockout(db.IpLockoutKey(ip), now)
+	if accountLocked && ipLocked {
+		return maxTime(accountUntil, ipUntil), true
+	} else if accountLocked {
+		return accountUntil, true
+	}
+	return ipUntil, ipLocked
+}
+
+func maxTime(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// Counts a failed login against the email and the address it came from.
+// Only a lockout is written to the database, as a security event.
+func recordFailure(database *db.Db, lockouts *db.Lockouts, policy db.LockoutPolicy, email string, ip string, now time.Time) {
+	if lockouts.RecordFailure(db.AccountLockoutKey(email), policy.AccountThreshold, now) {
+		database.Update(func(currentDatabase *db.Database) bool {
+			user, exists := currentDatabase.Users[email]
+			if !exists {
+				return false
+			}
+			currentDatabase.LogSecurityEvent(db.SecurityEvent{
+				Type:    db.LoginLockout,
+				UserId:  user.Id,
+				Details: fmt.Sprintf("account locked after repeated failed logins, last from %s", ip),
+			})
+			return true
+		})
+	}
+	lockouts.RecordFailure(db.IpLockoutKey(ip), policy.IpThreshold, now)
+}
+
+func respondLocked(w http.ResponseWriter, until time.Time, now time.Time) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(until.Sub(now).Seconds()))))
+	util.RespondWithError(w, http.StatusTooManyRequests, "too many failed logins, try again later")
+}
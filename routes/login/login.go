@@ -1,32 +1,80 @@
 package login
 
 import (
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/tade3910/chirpy/db"
 	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/signing"
 	"github.com/tade3910/chirpy/util"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type loginHandler struct {
-	db *db.Db
+	db                *db.Db
+	challengeLifetime time.Duration
+	credentials       *CredentialChecker
+	sessions          db.SessionPolicy
 }
 
-func GetLoginHandler(db *db.Db) *loginHandler {
+func GetLoginHandler(db *db.Db, challengeLifetime time.Duration, credentials *CredentialChecker, sessions db.SessionPolicy) *loginHandler {
 	return &loginHandler{
-		db: db,
+		db:                db,
+		challengeLifetime: challengeLifetime,
+		credentials:       credentials,
+		sessions:          sessions,
 	}
 }
 
+type loginResponse struct {
+	Token        string
+	RefreshToken string
+	db.PlainUser
+}
+
+// Starts a session for the user and hands out its tokens. The caller writes the database.
+func createSession(r *http.Request, database *db.Database, userId int, scopes []db.Scope, rememberMe bool, policy db.SessionPolicy, keys *signing.KeySet) (loginResponse, int, error) {
+	// Checked against a read from before the lock, the account may be gone since
+	user, exists := database.IDUsersMap[userId]
+	if !exists {
+		return loginResponse{}, 401, fmt.Errorf(invalidCredentials)
+	}
+	refreshToken, err := util.CreateRefreshToken()
+	if err != nil {
+		return loginResponse{}, 500, fmt.Errorf("could not create refresh token")
+	}
+	// Every token rotated from this login shares the family id
+	familyId, err := util.CreateRandomString(16)
+	if err != nil {
+		return loginResponse{}, 500, fmt.Errorf("could not create refresh token")
+	}
+	session := policy.NewSession(user, familyId, rememberMe, r.UserAgent(), util.GetClientIp(r))
+	session.Scopes = scopes
+	database.PutSession(refreshToken, session)
+	expiry_time := policy.AccessLifetime
+	token, err := util.CreateAcessToken(expiry_time, user.Id, string(user.GetRole()), familyId, db.FormatScopes(scopes), keys)
+	if err != nil {
+		return loginResponse{}, 500, fmt.Errorf("could not create token")
+	}
+	return loginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		PlainUser:    user.PlainUser,
+	}, 200, nil
+}
+
 type reqBody struct {
 	Password string
 	Email    string
+	// Optional space separated scopes for a restricted token, e.g. "chirps:read" for a dashboard
+	Scope string
+	// Keeps the session for the longer remember me lifetimes
+	RememberMe bool
 }
 
 func (handler *loginHandler) handlePost(w http.ResponseWriter, r *http.Request) {
-	jwtSecret, ok := r.Context().Value(apiConfig.JwtSecret).(string)
+	keys, ok := r.Context().Value(apiConfig.SigningKeys).(*signing.KeySet)
 	if !ok {
 		util.RespondWithError(w, http.StatusInternalServerError, "I messed up sharing the secret context")
 		return
@@ -36,47 +84,50 @@ func (handler *loginHandler) handlePost(w http.ResponseWriter, r *http.Request)
 		util.RespondWithError(w, http.StatusInternalServerError, "Invalid req body")
 		return
 	}
+	scopes, err := db.ParseScopes(body.Scope)
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	database, success := handler.db.GetDatabase()
 	if !success {
 		util.RespondWithError(w, http.StatusInternalServerError, "Couldn't read from database")
 		return
 	}
-	user, exists := database.Users[body.Email]
-	if !exists {
-		util.RespondWithError(w, http.StatusInternalServerError, "No such user exists")
+	now := time.Now().UTC()
+	ip := util.GetClientIp(r)
+	if until, locked := handler.credentials.Locked(database, body.Email, ip, now); locked {
+		respondLocked(w, until, now)
 		return
 	}
-	if bcrypt.CompareHashAndPassword(user.Password, []byte(body.Password)) == nil {
-		expiry_time := 1 * time.Hour
-		token, err := util.CreateAcessToken(expiry_time, user.Id, jwtSecret)
-		if err != nil {
-			util.RespondWithError(w, http.StatusInternalServerError, "Could not create token")
+	if user, ok := handler.credentials.CheckPassword(database, body.Email, body.Password, ip, now); ok {
+		if user.IsSuspended(time.Now().UTC()) {
+			util.RespondWithError(w, http.StatusForbidden, user.Suspension.Error().Error())
 			return
 		}
-		refreshToken, err := util.CreateRefreshToken()
-		session := db.GetNewSession(user)
-		if err != nil {
-			util.RespondWithError(w, http.StatusInternalServerError, "Could not create refresh token")
+		if user.HasTwoFactor() {
+			challenge, statusCode, err := handler.startChallenge(user, scopes, body.RememberMe)
+			if err != nil {
+				util.RespondWithError(w, statusCode, err.Error())
+				return
+			}
+			util.RespondWithJSON(w, statusCode, challenge)
 			return
 		}
-		database.Sessions[refreshToken] = session
-		handler.db.UpdateDatabase(database, db.NoDatabase)
-		responseBody := struct {
-			Token        string
-			RefreshToken string
-			db.PlainUser
-		}{
-			Token:        token,
-			RefreshToken: refreshToken,
-			PlainUser: db.PlainUser{
-				Email:         user.Email,
-				Id:            user.Id,
-				Is_chirpy_red: user.Is_chirpy_red,
-			},
+		var responseBody loginResponse
+		statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+			var statusCode int
+			var err error
+			responseBody, statusCode, err = createSession(r, database, user.Id, scopes, body.RememberMe, handler.sessions, keys)
+			return statusCode, err
+		})
+		if err != nil {
+			util.RespondWithError(w, statusCode, err.Error())
+			return
 		}
-		util.RespondWithJSON(w, 200, responseBody)
+		util.RespondWithJSON(w, statusCode, responseBody)
 	} else {
-		util.RespondWithError(w, http.StatusInternalServerError, "Invalid password")
+		util.RespondWithError(w, http.StatusUnauthorized, invalidCredentials)
 	}
 }
 
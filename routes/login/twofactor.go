@@ -0,0 +1,138 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/signing"
+	"github.com/tade3910/chirpy/util"
+)
+
+type challengeResponse struct {
+	TwoFactorRequired bool
+	ChallengeToken    string
+	Expires           time.Time
+}
+
+// The password was right, the client now has to come back to /api/login/2fa
+// with the challenge token and a code
+func (handler *loginHandler) startChallenge(user *db.User, scopes []db.Scope, rememberMe bool) (challengeResponse, int, error) {
+	token, err := util.CreateRandomString(32)
+	if err != nil {
+		return challengeResponse{}, 500, fmt.Errorf("could not create challenge token")
+	}
+	expires := time.Now().UTC().Add(handler.challengeLifetime)
+	if !handler.db.Update(func(database *db.Database) bool {
+		database.PutLoginChallenge(token, db.LoginChallenge{
+			UserId:     user.Id,
+			Expires:    expires,
+			Scopes:     scopes,
+			RememberMe: rememberMe,
+		})
+		return true
+	}) {
+		return challengeResponse{}, 500, fmt.Errorf("could not update database")
+	}
+	return challengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		Expires:           expires,
+	}, 200, nil
+}
+
+type twoFactorHandler struct {
+	db          *db.Db
+	credentials *CredentialChecker
+	sessions    db.SessionPolicy
+}
+
+func GetTwoFactorHandler(db *db.Db, credentials *CredentialChecker, sessions db.SessionPolicy) *twoFactorHandler {
+	return &twoFactorHandler{
+		db:          db,
+		credentials: credentials,
+		sessions:    sessions,
+	}
+}
+
+type twoFactorLogin struct {
+	ChallengeToken string
+	Code           string
+	RecoveryCode   string
+}
+
+// Finishes a login with either a code from the authenticator app or a recovery code
+func (handler *twoFactorHandler) completeLogin(r *http.Request, body *twoFactorLogin, keys *signing.KeySet) (loginResponse, int, error) {
+	database, success := handler.db.GetDatabase()
+	if !success {
+		return loginResponse{}, 500, fmt.Errorf("could not read from database")
+	}
+	challenge, exists := database.GetLoginChallenge(body.ChallengeToken)
+	if !exists {
+		return loginResponse{}, 401, fmt.Errorf("challenge token is invalid or has expired, log in again")
+	}
+	user, exists := database.IDUsersMap[challenge.UserId]
+	if !exists || !user.HasTwoFactor() {
+		handler.db.Update(func(currentDatabase *db.Database) bool {
+			currentDatabase.DeleteLoginChallenge(body.ChallengeToken)
+			return true
+		})
+		return loginResponse{}, 401, fmt.Errorf("challenge token is invalid or has expired, log in again")
+	}
+	now := time.Now().UTC()
+	if user.IsSuspended(now) {
+		return loginResponse{}, 403, user.Suspension.Error()
+	}
+	ip := util.GetClientIp(r)
+	if _, locked := handler.credentials.Locked(database, user.Email, ip, now); locked {
+		return loginResponse{}, 429, fmt.Errorf("too many failed logins, try again later")
+	}
+	if !handler.credentials.CheckTwoFactor(user, body.Code, body.RecoveryCode, ip, now) {
+		handler.db.Update(func(currentDatabase *db.Database) bool {
+			currentDatabase.FailLoginChallenge(body.ChallengeToken)
+			return true
+		})
+		return loginResponse{}, 401, fmt.Errorf("code is incorrect")
+	}
+	var responseBody loginResponse
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		// Taken under the lock so a challenge only ever gives one session
+		if _, exists := database.GetLoginChallenge(body.ChallengeToken); !exists {
+			return 401, fmt.Errorf("challenge token is invalid or has expired, log in again")
+		}
+		database.DeleteLoginChallenge(body.ChallengeToken)
+		var statusCode int
+		var err error
+		responseBody, statusCode, err = createSession(r, database, user.Id, challenge.Scopes, challenge.RememberMe, handler.sessions, keys)
+		return statusCode, err
+	})
+	if err != nil {
+		return loginResponse{}, statusCode, err
+	}
+	return responseBody, statusCode, nil
+}
+
+func (handler *twoFactorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	keys, ok := r.Context().Value(apiConfig.SigningKeys).(*signing.KeySet)
+	if !ok {
+		util.RespondWithError(w, http.StatusInternalServerError, "I messed up sharing the secret context")
+		return
+	}
+	body, ok := util.GetBody(r, &twoFactorLogin{})
+	if !ok {
+		util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+		return
+	}
+	responseBody, statusCode, err := handler.completeLogin(r, body, keys)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, responseBody)
+}
@@ -0,0 +1,220 @@
+package oauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/routes/login"
+	"github.com/tade3910/chirpy/util"
+)
+
+// The parameters of an authorization request, carried through the consent form
+type authorizeRequest struct {
+	ResponseType        string
+	ClientId            string
+	RedirectUri         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+func readAuthorizeRequest(r *http.Request) authorizeRequest {
+	return authorizeRequest{
+		ResponseType:        r.FormValue("response_type"),
+		ClientId:            r.FormValue("client_id"),
+		RedirectUri:         r.FormValue("redirect_uri"),
+		Scope:               r.FormValue("scope"),
+		State:               r.FormValue("state"),
+		CodeChallenge:       r.FormValue("code_challenge"),
+		CodeChallengeMethod: r.FormValue("code_challenge_method"),
+	}
+}
+
+// A base64url SHA-256 is always 43 characters
+var codeChallengePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+type authorizeHandler struct {
+	db           *db.Db
+	credentials  *login.CredentialChecker
+	codeLifetime time.Duration
+}
+
+func GetAuthorizeHandler(db *db.Db, credentials *login.CredentialChecker, codeLifetime time.Duration) *authorizeHandler {
+	return &authorizeHandler{
+		db:           db,
+		credentials:  credentials,
+		codeLifetime: codeLifetime,
+	}
+}
+
+// Errors about the client and redirect uri are shown to the user, as sending
+// them to an unchecked uri would make chirpy an open redirect
+func (request *authorizeRequest) validateClient(database *db.Database) (db.OAuthClient, error) {
+	client, exists := database.GetOAuthClient(request.ClientId)
+	if !exists {
+		return db.OAuthClient{}, fmt.Errorf("the app that sent you here is not registered with chirpy")
+	}
+	if request.RedirectUri == "" && len(client.RedirectUris) == 1 {
+		request.RedirectUri = client.RedirectUris[0]
+	}
+	if !client.HasRedirectUri(request.RedirectUri) {
+		return db.OAuthClient{}, fmt.Errorf("the app asked to send you back to an address it has not registered")
+	}
+	return client, nil
+}
+
+// Everything else goes back to the client. Returns the scopes the token will have.
+func (request *authorizeRequest) validate(client db.OAuthClient) ([]db.Scope, error) {
+	if request.ResponseType != "code" {
+		return nil, newError(unsupportedResponseType, "only the code response type is supported")
+	}
+	if request.CodeChallengeMethod != "S256" || !codeChallengePattern.MatchString(request.CodeChallenge) {
+		return nil, newError(invalidRequest, "a S256 code_challenge is required")
+	}
+	scopes, err := db.ParseScopes(request.Scope)
+	if err != nil {
+		return nil, newError(invalidScope, err.Error())
+	}
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return nil, newError(invalidScope, fmt.Sprintf("the client is not allowed the %s scope", scope))
+		}
+	}
+	request.Scope = db.FormatScopes(scopes)
+	return scopes, nil
+}
+
+func (request *authorizeRequest) redirect(w http.ResponseWriter, r *http.Request, params url.Values) {
+	redirectUri, _ := url.Parse(request.RedirectUri)
+	query := redirectUri.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if request.State != "" {
+		query.Set("state", request.State)
+	}
+	redirectUri.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectUri.String(), http.StatusFound)
+}
+
+func (request *authorizeRequest) redirectWithError(w http.ResponseWriter, r *http.Request, err error) {
+	request.redirect(w, r, url.Values{
+		"error":             {errorCode(err)},
+		"error_description": {err.Error()},
+	})
+}
+
+func getConsentPage(client db.OAuthClient, request authorizeRequest, scopes []db.Scope) consentPage {
+	redirectHost := request.RedirectUri
+	if parsed, err := url.Parse(request.RedirectUri); err == nil && parsed.Host != "" {
+		redirectHost = parsed.Host
+	}
+	descriptions := []string{}
+	for _, scope := range scopes {
+		descriptions = append(descriptions, scopeDescriptions[scope])
+	}
+	return consentPage{
+		ClientName:   client.Name,
+		RedirectHost: redirectHost,
+		Scopes:       descriptions,
+		Request:      request,
+	}
+}
+
+// Checks the credentials typed into the consent page and hands out a code.
+// When they are wrong returns whether the page should ask for a two factor code.
+func (handler *authorizeHandler) approve(r *http.Request, database *db.Database, request authorizeRequest, scopes []db.Scope) (string, bool, int, error) {
+	now := time.Now().UTC()
+	ip := util.GetClientIp(r)
+	email := r.PostFormValue("email")
+	if _, locked := handler.credentials.Locked(database, email, ip, now); locked {
+		return "", false, 429, fmt.Errorf("too many failed logins, try again later")
+	}
+	user, ok := handler.credentials.CheckPassword(database, email, r.PostFormValue("password"), ip, now)
+	if !ok {
+		return "", false, 401, fmt.Errorf("incorrect email or password")
+	}
+	if user.IsSuspended(now) {
+		return "", false, 403, user.Suspension.Error()
+	}
+	if user.HasTwoFactor() {
+		code := r.PostFormValue("code")
+		if code == "" {
+			return "", true, 401, fmt.Errorf("enter the code from your authenticator app")
+		}
+		if !handler.credentials.CheckTwoFactor(user, code, "", ip, now) {
+			return "", true, 401, fmt.Errorf("code is incorrect")
+		}
+	}
+	code, err := util.CreateRandomString(32)
+	if err != nil {
+		return "", false, 500, fmt.Errorf("could not create authorization code")
+	}
+	if !handler.db.Update(func(currentDatabase *db.Database) bool {
+		currentDatabase.PutAuthorizationCode(code, db.AuthorizationCode{
+			ClientId:      request.ClientId,
+			UserId:        user.Id,
+			RedirectUri:   request.RedirectUri,
+			Scopes:        scopes,
+			CodeChallenge: request.CodeChallenge,
+			Expires:       now.Add(handler.codeLifetime),
+		})
+		return true
+	}) {
+		return "", false, 500, fmt.Errorf("could not update database")
+	}
+	return code, false, 200, nil
+}
+
+func (handler *authorizeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderError(w, http.StatusBadRequest, "the authorization request could not be read")
+		return
+	}
+	database, success := handler.db.GetDatabase()
+	if !success {
+		renderError(w, http.StatusInternalServerError, "something went wrong, try again later")
+		return
+	}
+	request := readAuthorizeRequest(r)
+	client, err := request.validateClient(database)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	scopes, err := request.validate(client)
+	if err != nil {
+		request.redirectWithError(w, r, err)
+		return
+	}
+	page := getConsentPage(client, request, scopes)
+	if r.Method == http.MethodGet {
+		renderConsent(w, http.StatusOK, page)
+		return
+	}
+	if r.PostFormValue("decision") != "allow" {
+		request.redirectWithError(w, r, newError(accessDenied, "the user denied access"))
+		return
+	}
+	code, askForCode, statusCode, err := handler.approve(r, database, request, scopes)
+	if err != nil {
+		page.AskForCode = askForCode
+		page.Error = err.Error()
+		renderConsent(w, statusCode, page)
+		return
+	}
+	request.redirect(w, r, url.Values{"code": {code}})
+}
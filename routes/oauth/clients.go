@@ -0,0 +1,224 @@
+package oauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/denylist"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/util"
+)
+
+const (
+	maxNameLength      = 50
+	maxRedirectUris    = 10
+	maxClientsPerOwner = 10
+)
+
+type clientRequest struct {
+	Name         string
+	RedirectUris []string
+	Scopes       []db.Scope
+	// Server side apps that can keep a secret. Mobile and browser apps leave this out.
+	Confidential bool
+}
+
+// Redirects have to be https, http on the machine itself for local development,
+// or a reverse domain scheme like com.example.app:/callback for native apps
+func validateRedirectUri(uri string) error {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme == "" || parsed.Fragment != "" {
+		return fmt.Errorf("redirect uri %q must be an absolute uri without a fragment", uri)
+	}
+	switch parsed.Scheme {
+	case "https":
+		if parsed.Host == "" {
+			return fmt.Errorf("redirect uri %q needs a host", uri)
+		}
+	case "http":
+		host := parsed.Hostname()
+		if host != "localhost" && host != "127.0.0.1" && host != "::1" {
+			return fmt.Errorf("redirect uri %q can only use http for localhost", uri)
+		}
+	default:
+		if !strings.Contains(parsed.Scheme, ".") {
+			return fmt.Errorf("redirect uri %q must use https or a reverse domain scheme", uri)
+		}
+	}
+	return nil
+}
+
+func (request *clientRequest) validate() error {
+	if request.Name == "" || len(request.Name) > maxNameLength {
+		return fmt.Errorf("name must be between 1 and %d characters", maxNameLength)
+	}
+	if len(request.RedirectUris) == 0 || len(request.RedirectUris) > maxRedirectUris {
+		return fmt.Errorf("between 1 and %d redirect uris are required", maxRedirectUris)
+	}
+	for _, uri := range request.RedirectUris {
+		if err := validateRedirectUri(uri); err != nil {
+			return err
+		}
+	}
+	if len(request.Scopes) == 0 {
+		return fmt.Errorf("at least one scope is required, choose from %v", db.Scopes)
+	}
+	for _, scope := range request.Scopes {
+		if !scope.IsValid() {
+			return fmt.Errorf("unknown scope %q, choose from %v", scope, db.Scopes)
+		}
+	}
+	return nil
+}
+
+type clientInfo struct {
+	Id           string
+	Name         string
+	RedirectUris []string
+	Scopes       []db.Scope
+	Confidential bool
+	CreatedAt    time.Time
+	// Only returned when the client is registered
+	Secret string `json:",omitempty"`
+}
+
+func toClientInfo(client db.OAuthClient) clientInfo {
+	return clientInfo{
+		Id:           client.Id,
+		Name:         client.Name,
+		RedirectUris: client.RedirectUris,
+		Scopes:       client.Scopes,
+		Confidential: client.IsConfidential(),
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+type clientsHandler struct {
+	db       *db.Db
+	denylist *denylist.Denylist
+	sessions db.SessionPolicy
+}
+
+func GetClientsHandler(db *db.Db, denylist *denylist.Denylist, sessions db.SessionPolicy) *clientsHandler {
+	return &clientsHandler{
+		db:       db,
+		denylist: denylist,
+		sessions: sessions,
+	}
+}
+
+func (handler *clientsHandler) registerClient(ownerId int, request *clientRequest) (clientInfo, int, error) {
+	if err := request.validate(); err != nil {
+		return clientInfo{}, 400, err
+	}
+	id, err := util.CreateRandomString(16)
+	if err != nil {
+		return clientInfo{}, 500, fmt.Errorf("could not create client")
+	}
+	client := db.OAuthClient{
+		Id:           id,
+		OwnerId:      ownerId,
+		Name:         request.Name,
+		RedirectUris: request.RedirectUris,
+		Scopes:       request.Scopes,
+		CreatedAt:    time.Now().UTC(),
+	}
+	secret := ""
+	if request.Confidential {
+		secret, err = util.CreateRandomString(32)
+		if err != nil {
+			return clientInfo{}, 500, fmt.Errorf("could not create client")
+		}
+		client.SecretHash = util.HashToken(secret)
+	}
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		if len(database.GetUserOAuthClients(ownerId)) >= maxClientsPerOwner {
+			return 409, fmt.Errorf("you can register at most %d clients, delete one first", maxClientsPerOwner)
+		}
+		database.PutOAuthClient(client)
+		return 201, nil
+	})
+	if err != nil {
+		return clientInfo{}, statusCode, err
+	}
+	info := toClientInfo(client)
+	info.Secret = secret
+	return info, statusCode, nil
+}
+
+// Deleting a client logs it out of every account that authorized it
+func (handler *clientsHandler) deleteClient(ownerId int, id string) (int, error) {
+	var familyIds []string
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		client, exists := database.GetOAuthClient(id)
+		if !exists || client.OwnerId != ownerId {
+			return 404, fmt.Errorf("client doesn't exist")
+		}
+		familyIds = database.DeleteOAuthClient(id)
+		return 204, nil
+	})
+	if err != nil {
+		return statusCode, err
+	}
+	handler.denylist.Revoke(handler.sessions.RevokeUntil(), familyIds...)
+	return statusCode, nil
+}
+
+func (handler *clientsHandler) handleGet(w http.ResponseWriter, ownerId int) {
+	database, success := handler.db.GetDatabase()
+	if !success {
+		util.RespondWithError(w, http.StatusInternalServerError, "Couldn't read from database")
+		return
+	}
+	clients := []clientInfo{}
+	for _, client := range database.GetUserOAuthClients(ownerId) {
+		clients = append(clients, toClientInfo(client))
+	}
+	util.RespondWithJSON(w, 200, clients)
+}
+
+func (handler *clientsHandler) handlePost(w http.ResponseWriter, r *http.Request, ownerId int) {
+	request, ok := util.GetBody(r, &clientRequest{})
+	if !ok {
+		util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+		return
+	}
+	client, statusCode, err := handler.registerClient(ownerId, request)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, client)
+}
+
+func (handler *clientsHandler) handleDelete(w http.ResponseWriter, r *http.Request, ownerId int) {
+	statusCode, err := handler.deleteClient(ownerId, r.PathValue("id"))
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, nil)
+}
+
+// Handles /api/oauth/clients for the apps a user has registered
+func (handler *clientsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ownerId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.PathValue("id") == "":
+		handler.handleGet(w, ownerId)
+	case r.Method == http.MethodPost && r.PathValue("id") == "":
+		handler.handlePost(w, r, ownerId)
+	case r.Method == http.MethodDelete && r.PathValue("id") != "":
+		handler.handleDelete(w, r, ownerId)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,92 @@
+package oauth
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/tade3910/chirpy/db"
+)
+
+var scopeDescriptions = map[db.Scope]string{
+	db.ScopeChirpsRead:  "Read chirps, including your own",
+	db.ScopeChirpsWrite: "Post, edit and delete chirps as you",
+	db.ScopeUsersWrite:  "Change your profile",
+}
+
+type consentPage struct {
+	ClientName   string
+	RedirectHost string
+	Scopes       []string
+	Request      authorizeRequest
+	// Shown once the email and password were right and the account has two factor enabled
+	AskForCode bool
+	Error      string
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Authorize {{.ClientName}} - Chirpy</title>
+</head>
+<body>
+  <h1>{{.ClientName}} wants to use your Chirpy account</h1>
+  <p>After you allow it you will be sent back to {{.RedirectHost}}. It will be able to:</p>
+  <ul>
+    {{range .Scopes}}<li>{{.}}</li>
+    {{end}}
+  </ul>
+  <p>It will not see your password. You can revoke access at any time from your sessions.</p>
+  {{if .Error}}<p role="alert"><strong>{{.Error}}</strong></p>{{end}}
+  <form method="post" action="/oauth/authorize">
+    <input type="hidden" name="response_type" value="code">
+    <input type="hidden" name="client_id" value="{{.Request.ClientId}}">
+    <input type="hidden" name="redirect_uri" value="{{.Request.RedirectUri}}">
+    <input type="hidden" name="scope" value="{{.Request.Scope}}">
+    <input type="hidden" name="state" value="{{.Request.State}}">
+    <input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+    <input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+    <p><label>Email <input type="email" name="email" autocomplete="username" required></label></p>
+    <p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+    {{if .AskForCode}}<p><label>Code from your authenticator app <input type="text" name="code" inputmode="numeric" autocomplete="one-time-code" required></label></p>{{end}}
+    <button type="submit" name="decision" value="allow">Allow</button>
+    <button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+  </form>
+</body>
+</html>
+`))
+
+var errorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Authorization failed - Chirpy</title>
+</head>
+<body>
+  <h1>This app can't be authorized</h1>
+  <p>{{.}}</p>
+</body>
+</html>
+`))
+
+// The page takes a password, so other sites must not be able to frame it
+func setPageHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+}
+
+func renderConsent(w http.ResponseWriter, statusCode int, page consentPage) {
+	setPageHeaders(w)
+	w.WriteHeader(statusCode)
+	consentTemplate.Execute(w, page)
+}
+
+// Used when the client or redirect uri can't be trusted, so there is nowhere safe to send the user back to
+func renderError(w http.ResponseWriter, statusCode int, message string) {
+	setPageHeaders(w)
+	w.WriteHeader(statusCode)
+	errorTemplate.Execute(w, message)
+}
@@ -0,0 +1,147 @@
+package oauth
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/denylist"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/signing"
+	"github.com/tade3910/chirpy/util"
+)
+
+// A live token the client presented to introspection or revocation
+type foundToken struct {
+	session db.Session
+	// Nil for refresh tokens
+	claims *util.Claims
+}
+
+func findAccessToken(database *db.Database, revoked *denylist.Denylist, keys *signing.KeySet, token string) (foundToken, bool) {
+	claims := &util.Claims{}
+	if err := keys.Parse(token, claims); err != nil {
+		return foundToken{}, false
+	}
+	if revoked.IsRevoked(claims.ID) || revoked.IsRevoked(claims.SessionId) {
+		return foundToken{}, false
+	}
+	session, exists := database.GetFamilySession(claims.SessionId)
+	if !exists {
+		return foundToken{}, false
+	}
+	return foundToken{session: session, claims: claims}, true
+}
+
+func findRefreshToken(database *db.Database, token string) (foundToken, bool) {
+	session, exists := database.GetSession(token)
+	if !exists || session.Expires.Before(time.Now().UTC()) {
+		return foundToken{}, false
+	}
+	return foundToken{session: session}, true
+}
+
+// Looks for the kind of token the hint names first. Tokens issued to other
+// clients are treated as unknown so clients can't probe each other's tokens.
+func findToken(database *db.Database, revoked *denylist.Denylist, keys *signing.KeySet, client db.OAuthClient, token string, hint string) (foundToken, bool) {
+	found, exists := foundToken{}, false
+	if hint == "refresh_token" {
+		found, exists = findRefreshToken(database, token)
+		if !exists {
+			found, exists = findAccessToken(database, revoked, keys, token)
+		}
+	} else {
+		found, exists = findAccessToken(database, revoked, keys, token)
+		if !exists {
+			found, exists = findRefreshToken(database, token)
+		}
+	}
+	if !exists || found.session.ClientId != client.Id {
+		return foundToken{}, false
+	}
+	return found, true
+}
+
+type introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientId  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Expires   int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	SessionId string `json:"sid,omitempty"`
+	Id        string `json:"jti,omitempty"`
+}
+
+func toIntrospection(found foundToken) introspection {
+	response := introspection{
+		Active:    true,
+		Scope:     db.FormatScopes(found.session.Scopes),
+		ClientId:  found.session.ClientId,
+		Subject:   strconv.Itoa(found.session.User.Id),
+		SessionId: found.session.FamilyId,
+	}
+	if found.claims == nil {
+		response.TokenType = "refresh_token"
+		response.Expires = found.session.Expires.Unix()
+		response.IssuedAt = found.session.LastUsedAt.Unix()
+		return response
+	}
+	response.TokenType = string(util.Bearer)
+	response.Id = found.claims.ID
+	if found.claims.ExpiresAt != nil {
+		response.Expires = found.claims.ExpiresAt.Unix()
+	}
+	if found.claims.IssuedAt != nil {
+		response.IssuedAt = found.claims.IssuedAt.Unix()
+	}
+	return response
+}
+
+type introspectHandler struct {
+	db       *db.Db
+	denylist *denylist.Denylist
+}
+
+func GetIntrospectHandler(db *db.Db, denylist *denylist.Denylist) *introspectHandler {
+	return &introspectHandler{
+		db:       db,
+		denylist: denylist,
+	}
+}
+
+// RFC 7662, unknown and dead tokens are both just inactive
+func (handler *introspectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	keys, ok := r.Context().Value(apiConfig.SigningKeys).(*signing.KeySet)
+	if !ok {
+		util.RespondWithError(w, http.StatusInternalServerError, "I messed up sharing the secret context")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		respondWithError(w, http.StatusBadRequest, newError(invalidRequest, "body must be form encoded"))
+		return
+	}
+	database, success := handler.db.GetDatabase()
+	if !success {
+		util.RespondWithError(w, http.StatusInternalServerError, "Couldn't read from database")
+		return
+	}
+	client, statusCode, err := authenticateClient(r, database)
+	if err != nil {
+		respondWithError(w, statusCode, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	found, exists := findToken(database, handler.denylist, keys, client, r.PostFormValue("token"), r.PostFormValue("token_type_hint"))
+	if !exists {
+		util.RespondWithJSON(w, 200, introspection{Active: false})
+		return
+	}
+	util.RespondWithJSON(w, 200, toIntrospection(found))
+}
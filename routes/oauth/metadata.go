@@ -0,0 +1,53 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/util"
+)
+
+// RFC 8414 metadata so client libraries can find the endpoints on their own
+type metadata struct {
+	Issuer                        string     `json:"issuer"`
+	AuthorizationEndpoint         string     `json:"authorization_endpoint"`
+	TokenEndpoint                 string     `json:"token_endpoint"`
+	IntrospectionEndpoint         string     `json:"introspection_endpoint"`
+	RevocationEndpoint            string     `json:"revocation_endpoint"`
+	JwksUri                       string     `json:"jwks_uri"`
+	ScopesSupported               []db.Scope `json:"scopes_supported"`
+	ResponseTypesSupported        []string   `json:"response_types_supported"`
+	GrantTypesSupported           []string   `json:"grant_types_supported"`
+	CodeChallengeMethodsSupported []string   `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethods      []string   `json:"token_endpoint_auth_methods_supported"`
+}
+
+type metadataHandler struct {
+	metadata metadata
+}
+
+func GetMetadataHandler(publicUrl string) *metadataHandler {
+	return &metadataHandler{
+		metadata: metadata{
+			Issuer:                        publicUrl,
+			AuthorizationEndpoint:         publicUrl + "/oauth/authorize",
+			TokenEndpoint:                 publicUrl + "/oauth/token",
+			IntrospectionEndpoint:         publicUrl + "/oauth/introspect",
+			RevocationEndpoint:            publicUrl + "/oauth/revoke",
+			JwksUri:                       publicUrl + "/.well-known/jwks.json",
+			ScopesSupported:               db.Scopes,
+			ResponseTypesSupported:        []string{"code"},
+			GrantTypesSupported:           []string{"authorization_code", "refresh_token"},
+			CodeChallengeMethodsSupported: []string{"S256"},
+			TokenEndpointAuthMethods:      []string{"client_secret_basic", "client_secret_post", "none"},
+		},
+	}
+}
+
+func (handler *metadataHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	util.RespondWithJSON(w, 200, handler.metadata)
+}
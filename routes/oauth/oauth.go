@@ -0,0 +1,72 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/util"
+)
+
+// Error codes from RFC 6749, clients branch on these rather than the description
+const (
+	invalidRequest          = "invalid_request"
+	invalidClient           = "invalid_client"
+	invalidGrant            = "invalid_grant"
+	invalidScope            = "invalid_scope"
+	accessDenied            = "access_denied"
+	unsupportedGrantType    = "unsupported_grant_type"
+	unsupportedResponseType = "unsupported_response_type"
+	serverError             = "server_error"
+)
+
+type oauthError struct {
+	code        string
+	description string
+}
+
+func (err *oauthError) Error() string {
+	return err.description
+}
+
+func newError(code string, description string) error {
+	return &oauthError{code: code, description: description}
+}
+
+func errorCode(err error) string {
+	var known *oauthError
+	if errors.As(err, &known) {
+		return known.code
+	}
+	return serverError
+}
+
+// OAuth clients expect {"error", "error_description"} instead of the usual error body
+func respondWithError(w http.ResponseWriter, statusCode int, err error) {
+	w.Header().Set("Cache-Control", "no-store")
+	util.RespondWithJSON(w, statusCode, map[string]string{
+		"error":             errorCode(err),
+		"error_description": err.Error(),
+	})
+}
+
+// Reads the client from HTTP basic auth or the client_id and client_secret form
+// fields. Public clients only send their id, confidential ones need the secret.
+func authenticateClient(r *http.Request, database *db.Database) (db.OAuthClient, int, error) {
+	clientId, secret, basic := r.BasicAuth()
+	if !basic {
+		clientId = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+	if clientId == "" {
+		return db.OAuthClient{}, 401, newError(invalidClient, "client_id is required")
+	}
+	client, exists := database.GetOAuthClient(clientId)
+	if !exists {
+		return db.OAuthClient{}, 401, newError(invalidClient, "unknown client")
+	}
+	if client.IsConfidential() && !client.CheckSecret(secret) {
+		return db.OAuthClient{}, 401, newError(invalidClient, "client authentication failed")
+	}
+	return client, 200, nil
+}
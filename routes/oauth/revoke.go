@@ -0,0 +1,73 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/denylist"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/signing"
+	"github.com/tade3910/chirpy/util"
+)
+
+type revokeHandler struct {
+	db       *db.Db
+	denylist *denylist.Denylist
+	sessions db.SessionPolicy
+}
+
+func GetRevokeHandler(db *db.Db, denylist *denylist.Denylist, sessions db.SessionPolicy) *revokeHandler {
+	return &revokeHandler{
+		db:       db,
+		denylist: denylist,
+		sessions: sessions,
+	}
+}
+
+// Revoking a refresh token ends the whole session along with its access tokens,
+// revoking an access token only ends that token
+func (handler *revokeHandler) revoke(found foundToken) {
+	if found.claims == nil {
+		handler.db.Update(func(database *db.Database) bool {
+			return database.RevokeFamily(found.session.FamilyId) > 0
+		})
+		handler.denylist.Revoke(handler.sessions.RevokeUntil(), found.session.FamilyId)
+		return
+	}
+	until := handler.sessions.RevokeUntil()
+	if found.claims.ExpiresAt != nil {
+		until = found.claims.ExpiresAt.Time
+	}
+	handler.denylist.Revoke(until, found.claims.ID)
+}
+
+// RFC 7009, answers 200 for unknown tokens too since the client's goal is met either way
+func (handler *revokeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	keys, ok := r.Context().Value(apiConfig.SigningKeys).(*signing.KeySet)
+	if !ok {
+		util.RespondWithError(w, http.StatusInternalServerError, "I messed up sharing the secret context")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		respondWithError(w, http.StatusBadRequest, newError(invalidRequest, "body must be form encoded"))
+		return
+	}
+	database, success := handler.db.GetDatabase()
+	if !success {
+		util.RespondWithError(w, http.StatusInternalServerError, "Couldn't read from database")
+		return
+	}
+	client, statusCode, err := authenticateClient(r, database)
+	if err != nil {
+		respondWithError(w, statusCode, err)
+		return
+	}
+	if found, exists := findToken(database, handler.denylist, keys, client, r.PostFormValue("token"), r.PostFormValue("token_type_hint")); exists {
+		handler.revoke(found)
+	}
+	w.WriteHeader(http.StatusOK)
+}
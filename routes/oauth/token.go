@@ -0,0 +1,194 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/denylist"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/routes/refresh"
+	"github.com/tade3910/chirpy/signing"
+	"github.com/tade3910/chirpy/util"
+)
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+func (handler *tokenHandler) getTokenResponse(token string, refreshToken string, scopes []db.Scope) tokenResponse {
+	return tokenResponse{
+		AccessToken:  token,
+		TokenType:    string(util.Bearer),
+		ExpiresIn:    int(handler.sessions.AccessLifetime.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        db.FormatScopes(scopes),
+	}
+}
+
+// RFC 7636 allows 43 to 128 unreserved characters
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+
+func checkCodeVerifier(verifier string, challenge string) bool {
+	if !codeVerifierPattern.MatchString(verifier) {
+		return false
+	}
+	hash := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(hash[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+type tokenHandler struct {
+	db       *db.Db
+	denylist *denylist.Denylist
+	sessions db.SessionPolicy
+}
+
+func GetTokenHandler(db *db.Db, denylist *denylist.Denylist, sessions db.SessionPolicy) *tokenHandler {
+	return &tokenHandler{
+		db:       db,
+		denylist: denylist,
+		sessions: sessions,
+	}
+}
+
+// Turns the code into tokens and marks it used. Also returns whether the
+// database needs writing, which a reused code does even though it fails.
+func (handler *tokenHandler) redeemCode(r *http.Request, database *db.Database, client db.OAuthClient, keys *signing.KeySet) (tokenResponse, bool, int, error) {
+	code := r.PostFormValue("code")
+	authorization, exists := database.GetAuthorizationCode(code)
+	if !exists || authorization.ClientId != client.Id {
+		return tokenResponse{}, false, 400, newError(invalidGrant, "authorization code is invalid")
+	}
+	if authorization.FamilyId != "" {
+		// Codes are single use, so whoever sent it again may have stolen it.
+		// The tokens from the first exchange can't be trusted either.
+		revoked := database.RevokeFamily(authorization.FamilyId)
+		handler.denylist.Revoke(handler.sessions.RevokeUntil(), authorization.FamilyId)
+		database.LogSecurityEvent(db.SecurityEvent{
+			Type:    db.AuthorizationCodeReuse,
+			UserId:  authorization.UserId,
+			Details: fmt.Sprintf("authorization code for client %s was exchanged again, revoked %d sessions", client.Id, revoked),
+		})
+		return tokenResponse{}, true, 400, newError(invalidGrant, "authorization code was already used")
+	}
+	now := time.Now().UTC()
+	if authorization.Expires.Before(now) {
+		return tokenResponse{}, false, 400, newError(invalidGrant, "authorization code has expired")
+	}
+	if r.PostFormValue("redirect_uri") != authorization.RedirectUri {
+		return tokenResponse{}, false, 400, newError(invalidGrant, "redirect_uri doesn't match the authorization request")
+	}
+	if !checkCodeVerifier(r.PostFormValue("code_verifier"), authorization.CodeChallenge) {
+		return tokenResponse{}, false, 400, newError(invalidGrant, "code_verifier doesn't match the code_challenge")
+	}
+	user, exists := database.IDUsersMap[authorization.UserId]
+	if !exists || user.IsSuspended(now) {
+		return tokenResponse{}, false, 400, newError(invalidGrant, "the account is no longer available")
+	}
+	refreshToken, err := util.CreateRefreshToken()
+	if err != nil {
+		return tokenResponse{}, false, 500, fmt.Errorf("could not create refresh token")
+	}
+	familyId, err := util.CreateRandomString(16)
+	if err != nil {
+		return tokenResponse{}, false, 500, fmt.Errorf("could not create refresh token")
+	}
+	token, err := util.CreateAcessToken(handler.sessions.AccessLifetime, user.Id, string(user.GetRole()), familyId, db.FormatScopes(authorization.Scopes), keys)
+	if err != nil {
+		return tokenResponse{}, false, 500, fmt.Errorf("could not create access token")
+	}
+	// Apps keep working until the user revokes them, so they get the long lifetimes
+	session := handler.sessions.NewSession(user, familyId, true, r.UserAgent(), util.GetClientIp(r))
+	session.Scopes = authorization.Scopes
+	session.ClientId = client.Id
+	database.PutSession(refreshToken, session)
+	authorization.FamilyId = familyId
+	database.PutAuthorizationCode(code, authorization)
+	return handler.getTokenResponse(token, refreshToken, authorization.Scopes), true, 200, nil
+}
+
+// The code is looked up and marked used under the database lock, so of two
+// exchanges racing with the same code only one gets tokens
+func (handler *tokenHandler) exchangeCode(r *http.Request, keys *signing.KeySet) (tokenResponse, int, error) {
+	database, success := handler.db.GetDatabase()
+	if !success {
+		return tokenResponse{}, 500, fmt.Errorf("could not read from database")
+	}
+	client, statusCode, err := authenticateClient(r, database)
+	if err != nil {
+		return tokenResponse{}, statusCode, err
+	}
+	var response tokenResponse
+	if !handler.db.Update(func(currentDatabase *db.Database) bool {
+		var changed bool
+		response, changed, statusCode, err = handler.redeemCode(r, currentDatabase, client, keys)
+		return changed
+	}) {
+		return tokenResponse{}, 500, fmt.Errorf("could not update database")
+	}
+	if err != nil {
+		return tokenResponse{}, statusCode, err
+	}
+	return response, statusCode, nil
+}
+
+func (handler *tokenHandler) refreshToken(r *http.Request, keys *signing.KeySet) (tokenResponse, int, error) {
+	database, success := handler.db.GetDatabase()
+	if !success {
+		return tokenResponse{}, 500, fmt.Errorf("could not read from database")
+	}
+	client, statusCode, err := authenticateClient(r, database)
+	if err != nil {
+		return tokenResponse{}, statusCode, err
+	}
+	rotation, statusCode, err := refresh.Rotate(r, handler.db, handler.denylist, handler.sessions, r.PostFormValue("refresh_token"), client.Id, keys)
+	if statusCode == 500 {
+		return tokenResponse{}, statusCode, err
+	} else if err != nil {
+		return tokenResponse{}, 400, newError(invalidGrant, err.Error())
+	}
+	return handler.getTokenResponse(rotation.Token, rotation.RefreshToken, rotation.Session.Scopes), 200, nil
+}
+
+func (handler *tokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	keys, ok := r.Context().Value(apiConfig.SigningKeys).(*signing.KeySet)
+	if !ok {
+		util.RespondWithError(w, http.StatusInternalServerError, "I messed up sharing the secret context")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		respondWithError(w, http.StatusBadRequest, newError(invalidRequest, "body must be form encoded"))
+		return
+	}
+	var response tokenResponse
+	var statusCode int
+	var err error
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		response, statusCode, err = handler.exchangeCode(r, keys)
+	case "refresh_token":
+		response, statusCode, err = handler.refreshToken(r, keys)
+	default:
+		statusCode, err = 400, newError(unsupportedGrantType, "grant_type must be authorization_code or refresh_token")
+	}
+	if err != nil {
+		respondWithError(w, statusCode, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	util.RespondWithJSON(w, statusCode, response)
+}
@@ -6,46 +6,140 @@ import (
 	"time"
 
 	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/denylist"
 	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/signing"
 	"github.com/tade3910/chirpy/util"
 )
 
 type refreshHandler struct {
-	db *db.Db
+	db       *db.Db
+	denylist *denylist.Denylist
+	sessions db.SessionPolicy
 }
 
-func GetRefreshHandler(db *db.Db) *refreshHandler {
+func GetRefreshHandler(db *db.Db, denylist *denylist.Denylist, sessions db.SessionPolicy) *refreshHandler {
 	return &refreshHandler{
-		db: db,
+		db:       db,
+		denylist: denylist,
+		sessions: sessions,
 	}
 }
 
-func (handler *refreshHandler) refreshTokenToSession(oldRefreshToken string) (*db.Session, *db.Database, int, error) {
-	database, ok := handler.db.GetDatabase()
-	if !ok {
-		return nil, nil, 500, fmt.Errorf("couldn't get database")
+// Looks the refresh token up, revoking every session from its login when it was
+// already rotated. Also returns whether the database needs writing, which a
+// reused token does even though it fails.
+func refreshTokenToSession(currentDatabase *db.Database, revoked *denylist.Denylist, policy db.SessionPolicy, oldRefreshToken string) (*db.Session, bool, int, error) {
+	if rotated, reused := currentDatabase.GetRotatedSession(oldRefreshToken); reused {
+		// Only the holder of the newest token should ever present one, so a
+		// replayed token means it leaked. Log out everything from that login.
+		count := currentDatabase.RevokeFamily(rotated.FamilyId)
+		revoked.Revoke(policy.RevokeUntil(), rotated.FamilyId)
+		currentDatabase.LogSecurityEvent(db.SecurityEvent{
+			Type:    db.RefreshTokenReuse,
+			UserId:  rotated.UserId,
+			Details: fmt.Sprintf("token rotated at %s was presented again, revoked %d sessions", rotated.RotatedAt.Format(time.RFC3339), count),
+		})
+		return nil, true, 401, fmt.Errorf("refresh token was already used, every session from this login has been revoked")
 	}
-	session, ok := database.Sessions[oldRefreshToken]
+	session, ok := currentDatabase.GetSession(oldRefreshToken)
 	if !ok {
-		return nil, nil, 401, fmt.Errorf("refresh token doesn't exist in database")
+		return nil, false, 401, fmt.Errorf("refresh token doesn't exist in database")
 	} else if session.Expires.Before(time.Now().UTC()) {
-		return nil, nil, 401, fmt.Errorf("refresh token has expired")
+		return nil, false, 401, fmt.Errorf("refresh token has expired")
+	}
+	return &session, false, 200, nil
+}
+
+// Runs change on the session the refresh token belongs to, all under the
+// database lock so a token can only ever be used once
+func withSession(database *db.Db, revoked *denylist.Denylist, policy db.SessionPolicy, oldRefreshToken string, change func(*db.Database, *db.Session) (int, error)) (int, error) {
+	statusCode, err := 200, error(nil)
+	if !database.Update(func(currentDatabase *db.Database) bool {
+		var session *db.Session
+		var reused bool
+		session, reused, statusCode, err = refreshTokenToSession(currentDatabase, revoked, policy, oldRefreshToken)
+		if err != nil {
+			return reused
+		}
+		statusCode, err = change(currentDatabase, session)
+		return err == nil
+	}) {
+		return 500, fmt.Errorf("could not update database")
 	}
-	return &session, database, 200, nil
+	return statusCode, err
+}
+
+// A new access token and the refresh token that replaced the one presented
+type Rotation struct {
+	Token        string
+	RefreshToken string
+	Session      db.Session
 }
+
+// Exchanges a refresh token for new tokens, shared by /api/refresh and the OAuth
+// token endpoint. Sessions issued to an OAuth client can only be refreshed by that client.
+func Rotate(r *http.Request, database *db.Db, revoked *denylist.Denylist, policy db.SessionPolicy, oldRefreshToken string, clientId string, keys *signing.KeySet) (Rotation, int, error) {
+	refreshToken, err := util.CreateRefreshToken()
+	if err != nil {
+		return Rotation{}, 500, fmt.Errorf("could not create new refresh token")
+	}
+	var rotation Rotation
+	statusCode, err := withSession(database, revoked, policy, oldRefreshToken, func(currentDatabase *db.Database, session *db.Session) (int, error) {
+		if session.ClientId != clientId {
+			return 401, fmt.Errorf("refresh token was not issued to this client")
+		}
+		// The session holds a copy of the user from login, the role may have changed since
+		user, exists := currentDatabase.IDUsersMap[session.User.Id]
+		if !exists {
+			return 401, fmt.Errorf("user no longer exists")
+		}
+		if user.IsSuspended(time.Now().UTC()) {
+			return 403, user.Suspension.Error()
+		}
+		// Refreshing never widens what the login was allowed to do or outlives its max lifetime
+		newSession := policy.RefreshSession(*session, user, r.UserAgent(), util.GetClientIp(r))
+		if !newSession.Expires.After(time.Now().UTC()) {
+			return 401, fmt.Errorf("session has reached its maximum lifetime")
+		}
+		currentDatabase.RotateSession(oldRefreshToken, refreshToken, newSession)
+		expiry_time := policy.AccessLifetime
+		token, err := util.CreateAcessToken(expiry_time, user.Id, string(user.GetRole()), session.FamilyId, db.FormatScopes(session.Scopes), keys)
+		if err != nil {
+			return 500, fmt.Errorf("could not create access token")
+		}
+		rotated, _ := currentDatabase.GetSession(refreshToken)
+		rotation = Rotation{
+			Token:        token,
+			RefreshToken: refreshToken,
+			Session:      rotated,
+		}
+		return 200, nil
+	})
+	if err != nil {
+		return Rotation{}, statusCode, err
+	}
+	return rotation, statusCode, nil
+}
+
 func (handler *refreshHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
 	oldRefreshToken, err := util.GetAuthToken(r, util.Bearer)
 	if err != nil {
 		util.RespondWithError(w, 500, err.Error())
 		return
 	}
-	_, database, errorCode, err := handler.refreshTokenToSession(oldRefreshToken)
+	var familyId string
+	errorCode, err := withSession(handler.db, handler.denylist, handler.sessions, oldRefreshToken, func(database *db.Database, session *db.Session) (int, error) {
+		database.DeleteSession(oldRefreshToken)
+		familyId = session.FamilyId
+		return 201, nil
+	})
 	if err != nil {
 		util.RespondWithError(w, errorCode, err.Error())
 		return
 	}
-	delete(database.Sessions, oldRefreshToken)
-	handler.db.UpdateDatabase(database, db.NoDatabase)
+	// Logging out also ends the access tokens handed out for the session
+	handler.denylist.Revoke(handler.sessions.RevokeUntil(), familyId)
 	util.RespondWithJSON(w, 201, nil)
 }
 
@@ -55,31 +149,18 @@ func (handler *refreshHandler) handlePost(w http.ResponseWriter, r *http.Request
 		util.RespondWithError(w, 500, err.Error())
 		return
 	}
-	session, database, errorCode, err := handler.refreshTokenToSession(oldRefreshToken)
-	if err != nil {
-		util.RespondWithError(w, errorCode, err.Error())
-		return
-	}
-	refreshToken, err := util.CreateRefreshToken()
-	if err != nil {
-		util.RespondWithError(w, 500, "Could not create new refresh token")
-	}
-	database.Sessions[refreshToken] = db.GetNewSession(session.User)
-	delete(database.Sessions, oldRefreshToken)
 	// need to generate new access token
-	jwtSecret, ok := r.Context().Value(apiConfig.JwtSecret).(string)
+	keys, ok := r.Context().Value(apiConfig.SigningKeys).(*signing.KeySet)
 	if !ok {
 		util.RespondWithError(w, http.StatusInternalServerError, "I messed up sharing the secret context")
 		return
 	}
-	expiry_time := 1 * time.Hour
-	token, err := util.CreateAcessToken(expiry_time, session.User.Id, jwtSecret)
+	rotation, statusCode, err := Rotate(r, handler.db, handler.denylist, handler.sessions, oldRefreshToken, "", keys)
 	if err != nil {
-		util.RespondWithError(w, http.StatusInternalServerError, "Could not create access token")
+		util.RespondWithError(w, statusCode, err.Error())
 		return
 	}
-	handler.db.UpdateDatabase(database, db.NoDatabase)
-	util.RespondWithJSON(w, 200, map[string]string{"token": token})
+	util.RespondWithJSON(w, statusCode, map[string]string{"token": rotation.Token, "RefreshToken": rotation.RefreshToken})
 }
 
 func (handler *refreshHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
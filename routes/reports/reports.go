@@ -0,0 +1,128 @@
+package reports
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/util"
+)
+
+type reportsHandler struct {
+	db         *db.Db
+	targetType db.Target
+}
+
+// Handles POST /api/chirps/{id}/reports or /api/users/{id}/reports depending on targetType
+func GetReportsHandler(db *db.Db, targetType db.Target) *reportsHandler {
+	return &reportsHandler{
+		db:         db,
+		targetType: targetType,
+	}
+}
+
+type reportRequest struct {
+	Reason  db.ReportReason
+	Details string
+}
+
+const maxDetailsLength = 500
+
+func (request *reportRequest) validate() error {
+	if !request.Reason.IsValid() {
+		return fmt.Errorf("reason must be one of %v", db.ReportReasons)
+	}
+	if len(request.Details) > maxDetailsLength {
+		return fmt.Errorf("details can be at most %d characters", maxDetailsLength)
+	}
+	return nil
+}
+
+// Users can only report what they can see, and never themselves
+func (handler *reportsHandler) checkTarget(database *db.Database, reporterId int, targetId int) (int, error) {
+	switch handler.targetType {
+	case db.ChirpTarget:
+		chirp, exists := database.Chirps[targetId]
+		if !exists || database.IsChirpHiddenFrom(reporterId, chirp, time.Now().UTC()) {
+			return 404, fmt.Errorf("chirp with id %d doesn't exist", targetId)
+		}
+		if chirp.AuthorId == reporterId {
+			return 400, fmt.Errorf("you can't report your own chirp")
+		}
+	case db.UserTarget:
+		if _, exists := database.IDUsersMap[targetId]; !exists {
+			return 404, fmt.Errorf("user with id %d doesn't exist", targetId)
+		}
+		if targetId == reporterId {
+			return 400, fmt.Errorf("you can't report yourself")
+		}
+	}
+	return 200, nil
+}
+
+func (handler *reportsHandler) addReport(reporterId int, targetId int, request *reportRequest) (db.Report, int, error) {
+	var added db.Report
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		if statusCode, err := handler.checkTarget(database, reporterId, targetId); err != nil {
+			return statusCode, err
+		}
+		if database.HasOpenReport(reporterId, handler.targetType, targetId) {
+			return 409, fmt.Errorf("you already reported this %s", handler.targetType)
+		}
+		report := database.AddReport(db.Report{
+			ReporterId: reporterId,
+			TargetType: handler.targetType,
+			TargetId:   targetId,
+			Reason:     request.Reason,
+			Details:    request.Details,
+			Status:     db.ReportOpen,
+			CreatedAt:  time.Now().UTC(),
+		})
+		added = report
+		return 201, nil
+	})
+	if err != nil {
+		return db.Report{}, statusCode, err
+	}
+	return added, statusCode, nil
+}
+
+func (handler *reportsHandler) handlePost(w http.ResponseWriter, r *http.Request) {
+	reporterId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	targetId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest, "id must be an int")
+		return
+	}
+	request, ok := util.GetBody(r, &reportRequest{})
+	if !ok {
+		util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+		return
+	}
+	if err := request.validate(); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	report, statusCode, err := handler.addReport(reporterId, targetId, request)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, report)
+}
+
+func (handler *reportsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		handler.handlePost(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
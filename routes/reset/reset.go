@@ -0,0 +1,138 @@
+package reset
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/mailer"
+	"github.com/tade3910/chirpy/password"
+	"github.com/tade3910/chirpy/util"
+)
+
+type resetHandler struct {
+	db       *db.Db
+	mailer   mailer.Mailer
+	lifetime time.Duration
+}
+
+func GetResetHandler(db *db.Db, mailer mailer.Mailer, lifetime time.Duration) *resetHandler {
+	return &resetHandler{
+		db:       db,
+		mailer:   mailer,
+		lifetime: lifetime,
+	}
+}
+
+func (handler *resetHandler) sendReset(email string) error {
+	token, err := util.CreateRandomString(32)
+	if err != nil {
+		return err
+	}
+	found := false
+	if !handler.db.Update(func(database *db.Database) bool {
+		user, exists := database.Users[email]
+		if !exists {
+			return false
+		}
+		database.PutEmailToken(util.HashToken(token), db.EmailToken{
+			UserId:  user.Id,
+			Purpose: db.ResetPassword,
+			Expires: time.Now().UTC().Add(handler.lifetime),
+		})
+		found = true
+		return true
+	}) {
+		return fmt.Errorf("could not update database")
+	}
+	if !found {
+		return nil
+	}
+	body := fmt.Sprintf("Someone asked to reset your Chirpy password. If it was you, POST this token with your new password to /api/password-reset/confirm:\n\n%s\n\nIt expires in %s. If it wasn't you, you can ignore this email.", token, handler.lifetime)
+	return handler.mailer.Send(email, "Reset your Chirpy password", body)
+}
+
+// Always accepts so the response can't be used to find out which emails have
+// accounts. The reset is sent in the background so the timing can't either.
+func (handler *resetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, ok := util.GetBody(r, &struct{ Email string }{})
+	if !ok {
+		util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+		return
+	}
+	go func(email string) {
+		if err := handler.sendReset(email); err != nil {
+			fmt.Println("Problem sending password reset:", err)
+		}
+	}(body.Email)
+	util.RespondWithJSON(w, 202, nil)
+}
+
+type confirmHandler struct {
+	db     *db.Db
+	policy password.Policy
+	hasher password.Hasher
+}
+
+func GetConfirmHandler(db *db.Db, policy password.Policy, hasher password.Hasher) *confirmHandler {
+	return &confirmHandler{
+		db:     db,
+		policy: policy,
+		hasher: hasher,
+	}
+}
+
+type resetConfirmation struct {
+	Token       string
+	NewPassword string
+}
+
+// Resetting proves the user owns the email, so it also verifies it
+func (handler *confirmHandler) resetPassword(confirmation *resetConfirmation) (int, error) {
+	if err := handler.policy.Validate(confirmation.NewPassword); err != nil {
+		return 400, err
+	}
+	// Hashed before taking the database lock since it is slow on purpose
+	hashPassowrd, err := handler.hasher.Hash(confirmation.NewPassword)
+	if err != nil {
+		return 500, fmt.Errorf("could not hash password")
+	}
+	return handler.db.Apply(func(database *db.Database) (int, error) {
+		token, ok := database.ConsumeEmailToken(util.HashToken(confirmation.Token), db.ResetPassword)
+		if !ok {
+			return 400, fmt.Errorf("reset token is invalid or has expired")
+		}
+		user, exists := database.IDUsersMap[token.UserId]
+		if !exists {
+			return 404, fmt.Errorf("user doesn't exist")
+		}
+		user.Password = hashPassowrd
+		user.Is_verified = true
+		database.PutUser(user)
+		database.RevokeUserSessions(user.Id)
+		return 204, nil
+	})
+}
+
+func (handler *confirmHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	confirmation, ok := util.GetBody(r, &resetConfirmation{})
+	if !ok {
+		util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+		return
+	}
+	statusCode, err := handler.resetPassword(confirmation)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, nil)
+}
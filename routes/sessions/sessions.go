@@ -0,0 +1,127 @@
+package sessions
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/denylist"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/util"
+)
+
+// What a user sees of one of their logged in devices
+type sessionInfo struct {
+	Id          string
+	TokenPrefix string
+	UserAgent   string
+	Ip          string
+	CreatedAt   time.Time
+	LastUsedAt  time.Time
+	Expires     time.Time
+	Scopes      []db.Scope `json:",omitempty"`
+	// The app the user authorized, for sessions from OAuth
+	ClientId   string `json:",omitempty"`
+	ClientName string `json:",omitempty"`
+	Current    bool
+}
+
+type sessionsHandler struct {
+	db       *db.Db
+	denylist *denylist.Denylist
+	sessions db.SessionPolicy
+}
+
+func GetSessionsHandler(db *db.Db, denylist *denylist.Denylist, sessions db.SessionPolicy) *sessionsHandler {
+	return &sessionsHandler{
+		db:       db,
+		denylist: denylist,
+		sessions: sessions,
+	}
+}
+
+func (handler *sessionsHandler) handleGet(w http.ResponseWriter, r *http.Request) {
+	userId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	database, ok := handler.db.GetDatabase()
+	if !ok {
+		util.RespondWithError(w, http.StatusInternalServerError, "Couldn't read from database")
+		return
+	}
+	currentId := apiConfig.GetSessionId(r)
+	sessions := []sessionInfo{}
+	for _, session := range database.GetUserSessions(userId) {
+		sessions = append(sessions, sessionInfo{
+			Id:          session.FamilyId,
+			TokenPrefix: session.TokenPrefix,
+			UserAgent:   session.UserAgent,
+			Ip:          session.Ip,
+			CreatedAt:   session.CreatedAt,
+			LastUsedAt:  session.LastUsedAt,
+			Expires:     session.Expires,
+			Scopes:      session.Scopes,
+			ClientId:    session.ClientId,
+			ClientName:  database.OAuthClients[session.ClientId].Name,
+			Current:     session.FamilyId == currentId,
+		})
+	}
+	util.RespondWithJSON(w, 200, sessions)
+}
+
+// Revokes one session, or every session when sessionId is empty. Access
+// tokens issued for a revoked session stop working with it.
+func (handler *sessionsHandler) revokeSessions(userId int, sessionId string) (int, error) {
+	revoked := []string{}
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		if sessionId == "" {
+			for _, session := range database.GetUserSessions(userId) {
+				revoked = append(revoked, session.FamilyId)
+			}
+			database.RevokeUserSessions(userId)
+		} else {
+			// Other users' sessions look the same as ones that don't exist
+			if !database.HasSession(sessionId, userId) {
+				return 404, fmt.Errorf("session doesn't exist")
+			}
+			database.RevokeFamily(sessionId)
+			revoked = append(revoked, sessionId)
+		}
+		return 204, nil
+	})
+	if err != nil {
+		return statusCode, err
+	}
+	if !handler.denylist.Revoke(handler.sessions.RevokeUntil(), revoked...) {
+		return 500, fmt.Errorf("could not update token denylist")
+	}
+	return 204, nil
+}
+
+func (handler *sessionsHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	userId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	statusCode, err := handler.revokeSessions(userId, r.PathValue("id"))
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, nil)
+}
+
+func (handler *sessionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.PathValue("id") == "":
+		handler.handleGet(w, r)
+	case r.Method == http.MethodDelete:
+		handler.handleDelete(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
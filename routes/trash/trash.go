@@ -0,0 +1,63 @@
+package trash
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/util"
+)
+
+type trashHandler struct {
+	db *db.Db
+}
+
+func GetTrashHandler(db *db.Db) *trashHandler {
+	return &trashHandler{
+		db: db,
+	}
+}
+
+func (handler *trashHandler) getTrash(authorId int) ([]db.Chirp, bool) {
+	database, success := handler.db.GetDatabase()
+	if !success {
+		return nil, false
+	}
+	now := time.Now().UTC()
+	retention := handler.db.GetTrashRetention()
+	trash := []db.Chirp{}
+	for _, chirp := range database.Chirps {
+		if chirp.AuthorId == authorId && chirp.IsInTrash(now, retention) {
+			trash = append(trash, chirp)
+		}
+	}
+	sort.Slice(trash, func(i, j int) bool {
+		return trash[i].Id < trash[j].Id
+	})
+	return trash, true
+}
+
+func (handler *trashHandler) handleGet(w http.ResponseWriter, r *http.Request) {
+	authorId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	trash, ok := handler.getTrash(authorId)
+	if !ok {
+		util.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	util.RespondWithJSON(w, 200, trash)
+}
+
+func (handler *trashHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handler.handleGet(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,130 @@
+package user
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/util"
+)
+
+const (
+	maxAvatarSize   = 1 << 20
+	AvatarUrlPrefix = "/avatars/"
+)
+
+var avatarExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+type avatarHandler struct {
+	db  *db.Db
+	dir string
+}
+
+// Avatars are written to dir and expected to be served under AvatarUrlPrefix
+func GetAvatarHandler(db *db.Db, dir string) *avatarHandler {
+	return &avatarHandler{
+		db:  db,
+		dir: dir,
+	}
+}
+
+// Reads the uploaded image and checks it really is one of the allowed types
+func readAvatar(r *http.Request) ([]byte, string, error) {
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		return nil, "", fmt.Errorf("avatar file missing from form")
+	}
+	defer file.Close()
+	image, err := io.ReadAll(io.LimitReader(file, maxAvatarSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read avatar")
+	}
+	if len(image) > maxAvatarSize {
+		return nil, "", fmt.Errorf("avatar can be at most %d bytes", maxAvatarSize)
+	}
+	extension, ok := avatarExtensions[http.DetectContentType(image)]
+	if !ok {
+		return nil, "", fmt.Errorf("avatar must be a png, jpeg or gif")
+	}
+	return image, extension, nil
+}
+
+func (handler *avatarHandler) saveAvatar(userId int, image []byte, extension string) (db.PlainUser, error) {
+	database, success := handler.db.GetDatabase()
+	if !success {
+		return db.PlainUser{}, fmt.Errorf("could not read from database")
+	}
+	if _, exists := database.IDUsersMap[userId]; !exists {
+		return db.PlainUser{}, fmt.Errorf("user doesn't exist")
+	}
+	suffix, err := util.CreateRandomString(8)
+	if err != nil {
+		return db.PlainUser{}, err
+	}
+	if err := os.MkdirAll(handler.dir, 0755); err != nil {
+		return db.PlainUser{}, err
+	}
+	fileName := fmt.Sprintf("%d-%s%s", userId, suffix, extension)
+	if err := os.WriteFile(filepath.Join(handler.dir, fileName), image, 0644); err != nil {
+		return db.PlainUser{}, err
+	}
+	var oldAvatar string
+	var updated db.PlainUser
+	_, err = handler.db.Apply(func(database *db.Database) (int, error) {
+		user, exists := database.IDUsersMap[userId]
+		if !exists {
+			return 404, fmt.Errorf("user doesn't exist")
+		}
+		oldAvatar = user.AvatarUrl
+		user.AvatarUrl = AvatarUrlPrefix + fileName
+		database.PutUser(user)
+		updated = user.PlainUser
+		return 200, nil
+	})
+	if err != nil {
+		os.Remove(filepath.Join(handler.dir, fileName))
+		return db.PlainUser{}, err
+	}
+	if oldAvatar != "" {
+		os.Remove(filepath.Join(handler.dir, strings.TrimPrefix(oldAvatar, AvatarUrlPrefix)))
+	}
+	return updated, nil
+}
+
+func (handler *avatarHandler) handlePost(w http.ResponseWriter, r *http.Request) {
+	userId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 2*maxAvatarSize)
+	image, extension, err := readAvatar(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	response, err := handler.saveAvatar(userId, image, extension)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, 200, response)
+}
+
+func (handler *avatarHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		handler.handlePost(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
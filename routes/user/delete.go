@@ -0,0 +1,65 @@
+package user
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/util"
+)
+
+// Deleting an account needs the password again so a stolen access token can't
+// do it. Wrong passwords count towards the login lockout.
+func (handler *userHandler) deleteUser(r *http.Request, userId int, password string) (int, error) {
+	database, success := handler.db.GetDatabase()
+	if !success {
+		return 500, fmt.Errorf("could not read from database")
+	}
+	user, exists := database.IDUsersMap[userId]
+	if !exists {
+		return 404, fmt.Errorf("user doesn't exist")
+	}
+	now := time.Now().UTC()
+	ip := util.GetClientIp(r)
+	if _, locked := handler.credentials.Locked(database, user.Email, ip, now); locked {
+		return 429, fmt.Errorf("too many failed attempts, try again later")
+	}
+	if _, ok := handler.credentials.CheckPassword(database, user.Email, password, ip, now); !ok {
+		return 403, fmt.Errorf("password is incorrect")
+	}
+	var avatarUrl string
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		user, exists := database.IDUsersMap[userId]
+		if !exists {
+			return 404, fmt.Errorf("user doesn't exist")
+		}
+		avatarUrl = user.AvatarUrl
+		database.DeleteUser(user, handler.chirpPolicy)
+		return 204, nil
+	})
+	if err != nil {
+		return statusCode, err
+	}
+	if avatarUrl != "" {
+		os.Remove(filepath.Join(handler.avatarDir, strings.TrimPrefix(avatarUrl, AvatarUrlPrefix)))
+	}
+	return statusCode, nil
+}
+
+func (handler *userHandler) handleDelete(w http.ResponseWriter, r *http.Request, userId int) {
+	body, ok := util.GetBody(r, &struct{ Password string }{})
+	if !ok {
+		util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+		return
+	}
+	statusCode, err := handler.deleteUser(r, userId, body.Password)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, nil)
+}
@@ -0,0 +1,118 @@
+package user
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/password"
+	"github.com/tade3910/chirpy/routes/login"
+	"github.com/tade3910/chirpy/util"
+)
+
+type passwordHandler struct {
+	db          *db.Db
+	policy      password.Policy
+	hasher      password.Hasher
+	credentials *login.CredentialChecker
+	sessions    db.SessionPolicy
+}
+
+func GetPasswordHandler(db *db.Db, policy password.Policy, hasher password.Hasher, credentials *login.CredentialChecker, sessions db.SessionPolicy) *passwordHandler {
+	return &passwordHandler{
+		db:          db,
+		policy:      policy,
+		hasher:      hasher,
+		credentials: credentials,
+		sessions:    sessions,
+	}
+}
+
+type passwordChange struct {
+	CurrentPassword string
+	NewPassword     string
+}
+
+// Changes the password and revokes every existing session, handing back a
+// fresh refresh token so the caller stays logged in. Wrong current passwords
+// count towards the login lockout.
+func (handler *passwordHandler) changePassword(r *http.Request, userId int, change *passwordChange) (string, int, error) {
+	database, success := handler.db.GetDatabase()
+	if !success {
+		return "", 500, fmt.Errorf("could not read from database")
+	}
+	user, exists := database.IDUsersMap[userId]
+	if !exists {
+		return "", 404, fmt.Errorf("user doesn't exist")
+	}
+	now := time.Now().UTC()
+	ip := util.GetClientIp(r)
+	if _, locked := handler.credentials.Locked(database, user.Email, ip, now); locked {
+		return "", 429, fmt.Errorf("too many failed attempts, try again later")
+	}
+	if _, ok := handler.credentials.CheckPassword(database, user.Email, change.CurrentPassword, ip, now); !ok {
+		return "", 403, fmt.Errorf("current password is incorrect")
+	}
+	if err := handler.policy.Validate(change.NewPassword); err != nil {
+		return "", 400, err
+	}
+	hashPassowrd, err := handler.hasher.Hash(change.NewPassword)
+	if err != nil {
+		return "", 500, fmt.Errorf("could not hash password")
+	}
+	refreshToken, err := util.CreateRefreshToken()
+	if err != nil {
+		return "", 500, fmt.Errorf("could not create refresh token")
+	}
+	familyId, err := util.CreateRandomString(16)
+	if err != nil {
+		return "", 500, fmt.Errorf("could not create refresh token")
+	}
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		user, exists := database.IDUsersMap[userId]
+		if !exists {
+			return 404, fmt.Errorf("user doesn't exist")
+		}
+		// The new session lasts as long as the one the password was changed from
+		current, _ := database.GetFamilySession(apiConfig.GetSessionId(r))
+		user.Password = hashPassowrd
+		database.PutUser(user)
+		database.RevokeUserSessions(userId)
+		database.PutSession(refreshToken, handler.sessions.NewSession(user, familyId, current.RememberMe, r.UserAgent(), util.GetClientIp(r)))
+		return 200, nil
+	})
+	if err != nil {
+		return "", statusCode, err
+	}
+	return refreshToken, statusCode, nil
+}
+
+func (handler *passwordHandler) handlePost(w http.ResponseWriter, r *http.Request) {
+	userId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	change, ok := util.GetBody(r, &passwordChange{})
+	if !ok {
+		util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+		return
+	}
+	refreshToken, statusCode, err := handler.changePassword(r, userId, change)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, map[string]string{"RefreshToken": refreshToken})
+}
+
+func (handler *passwordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		handler.handlePost(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,80 @@
+package user
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/util"
+)
+
+const (
+	block = "block"
+	mute  = "mute"
+)
+
+func (handler *userHandler) updateRelation(userId int, otherId int, relation string, add bool) (int, error) {
+	if userId == otherId {
+		return 400, fmt.Errorf("you can't %s yourself", relation)
+	}
+	return handler.db.Apply(func(database *db.Database) (int, error) {
+		if _, exists := database.IDUsersMap[otherId]; !exists {
+			return 404, fmt.Errorf("user doesn't exist")
+		}
+		switch {
+		case relation == block && add:
+			database.Block(userId, otherId)
+		case relation == block:
+			database.Unblock(userId, otherId)
+		case add:
+			database.Mute(userId, otherId)
+		default:
+			database.Unmute(userId, otherId)
+		}
+		return 204, nil
+	})
+}
+
+// Handles POST and DELETE on /api/users/{id}/block and /api/users/{id}/mute
+func (handler *userHandler) handleRelation(w http.ResponseWriter, r *http.Request, userId int, otherId string, relation string) {
+	var add bool
+	switch r.Method {
+	case http.MethodPost:
+		add = true
+	case http.MethodDelete:
+		add = false
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(otherId)
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest, "id must be an int")
+		return
+	}
+	statusCode, err := handler.updateRelation(userId, id, relation, add)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, nil)
+}
+
+// Handles GET /api/users/me/blocks and /api/users/me/mutes
+func (handler *userHandler) handleListRelations(w http.ResponseWriter, r *http.Request, userId int, relation string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	database, success := handler.db.GetDatabase()
+	if !success {
+		util.RespondWithError(w, http.StatusInternalServerError, "Couldn't read from database")
+		return
+	}
+	if relation == block {
+		util.RespondWithJSON(w, 200, database.GetBlocks(userId))
+		return
+	}
+	util.RespondWithJSON(w, 200, database.GetMutes(userId))
+}
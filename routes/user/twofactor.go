@@ -0,0 +1,264 @@
+package user
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/routes/login"
+	"github.com/tade3910/chirpy/totp"
+	"github.com/tade3910/chirpy/util"
+)
+
+// Shown as the account's name in authenticator apps
+const totpIssuer = "Chirpy"
+
+const recoveryCodeCount = 10
+
+type twoFactorStatus struct {
+	Enabled           bool
+	RecoveryCodesLeft int
+}
+
+type enrollment struct {
+	Secret string
+	Uri    string
+}
+
+type twoFactorCode struct {
+	Code         string
+	RecoveryCode string
+}
+
+type twoFactorHandler struct {
+	db          *db.Db
+	credentials *login.CredentialChecker
+}
+
+func GetTwoFactorHandler(db *db.Db, credentials *login.CredentialChecker) *twoFactorHandler {
+	return &twoFactorHandler{
+		db:          db,
+		credentials: credentials,
+	}
+}
+
+func (handler *twoFactorHandler) getStatus(userId int) (twoFactorStatus, int, error) {
+	database, success := handler.db.GetDatabase()
+	if !success {
+		return twoFactorStatus{}, 500, fmt.Errorf("could not read from database")
+	}
+	user, exists := database.IDUsersMap[userId]
+	if !exists {
+		return twoFactorStatus{}, 404, fmt.Errorf("user doesn't exist")
+	}
+	if !user.HasTwoFactor() {
+		return twoFactorStatus{}, 200, nil
+	}
+	return twoFactorStatus{
+		Enabled:           true,
+		RecoveryCodesLeft: len(user.TwoFactor.RecoveryCodeHashes),
+	}, 200, nil
+}
+
+// Generates a new secret that stays pending until a code from it is confirmed.
+// Needs the password so a stolen access token can't enable it with the
+// thief's own secret, which only the enrollment hands out.
+func (handler *twoFactorHandler) enroll(r *http.Request, userId int, password string) (enrollment, int, error) {
+	database, success := handler.db.GetDatabase()
+	if !success {
+		return enrollment{}, 500, fmt.Errorf("could not read from database")
+	}
+	user, exists := database.IDUsersMap[userId]
+	if !exists {
+		return enrollment{}, 404, fmt.Errorf("user doesn't exist")
+	}
+	if user.HasTwoFactor() {
+		return enrollment{}, 409, fmt.Errorf("two factor authentication is already enabled")
+	}
+	now := time.Now().UTC()
+	ip := util.GetClientIp(r)
+	if _, locked := handler.credentials.Locked(database, user.Email, ip, now); locked {
+		return enrollment{}, 429, fmt.Errorf("too many failed attempts, try again later")
+	}
+	if _, ok := handler.credentials.CheckPassword(database, user.Email, password, ip, now); !ok {
+		return enrollment{}, 403, fmt.Errorf("password is incorrect")
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return enrollment{}, 500, fmt.Errorf("could not create secret")
+	}
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		user, exists := database.IDUsersMap[userId]
+		if !exists {
+			return 404, fmt.Errorf("user doesn't exist")
+		}
+		if user.HasTwoFactor() {
+			return 409, fmt.Errorf("two factor authentication is already enabled")
+		}
+		user.TwoFactor = &db.TwoFactor{Secret: secret}
+		database.PutUser(user)
+		return 201, nil
+	})
+	if err != nil {
+		return enrollment{}, statusCode, err
+	}
+	return enrollment{
+		Secret: secret,
+		Uri:    totp.Uri(totpIssuer, user.Email, secret),
+	}, statusCode, nil
+}
+
+// Turning it off needs a current code so a stolen access token can't. Wrong
+// codes count towards the login lockout so they can't be guessed either.
+func (handler *twoFactorHandler) disable(r *http.Request, userId int, body *twoFactorCode) (int, error) {
+	database, success := handler.db.GetDatabase()
+	if !success {
+		return 500, fmt.Errorf("could not read from database")
+	}
+	user, exists := database.IDUsersMap[userId]
+	if !exists {
+		return 404, fmt.Errorf("user doesn't exist")
+	}
+	if !user.HasTwoFactor() {
+		return 409, fmt.Errorf("two factor authentication is not enabled")
+	}
+	now := time.Now().UTC()
+	ip := util.GetClientIp(r)
+	if _, locked := handler.credentials.Locked(database, user.Email, ip, now); locked {
+		return 429, fmt.Errorf("too many failed attempts, try again later")
+	}
+	if !handler.credentials.CheckTwoFactor(user, body.Code, body.RecoveryCode, ip, now) {
+		return 403, fmt.Errorf("code is incorrect")
+	}
+	return handler.db.Apply(func(database *db.Database) (int, error) {
+		user, exists := database.IDUsersMap[userId]
+		if !exists {
+			return 404, fmt.Errorf("user doesn't exist")
+		}
+		user.TwoFactor = nil
+		database.PutUser(user)
+		return 204, nil
+	})
+}
+
+func (handler *twoFactorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		status, statusCode, err := handler.getStatus(userId)
+		if err != nil {
+			util.RespondWithError(w, statusCode, err.Error())
+			return
+		}
+		util.RespondWithJSON(w, statusCode, status)
+	case http.MethodPost:
+		body, ok := util.GetBody(r, &struct{ Password string }{})
+		if !ok {
+			util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+			return
+		}
+		enrollment, statusCode, err := handler.enroll(r, userId, body.Password)
+		if err != nil {
+			util.RespondWithError(w, statusCode, err.Error())
+			return
+		}
+		util.RespondWithJSON(w, statusCode, enrollment)
+	case http.MethodDelete:
+		body, ok := util.GetBody(r, &twoFactorCode{})
+		if !ok {
+			util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+			return
+		}
+		statusCode, err := handler.disable(r, userId, body)
+		if err != nil {
+			util.RespondWithError(w, statusCode, err.Error())
+			return
+		}
+		util.RespondWithJSON(w, statusCode, nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type twoFactorConfirmHandler struct {
+	db *db.Db
+}
+
+func GetTwoFactorConfirmHandler(db *db.Db) *twoFactorConfirmHandler {
+	return &twoFactorConfirmHandler{
+		db: db,
+	}
+}
+
+// Enables two factor authentication once the user proves their app has the
+// secret. Only a secret from a password checked enrollment can be confirmed.
+// The recovery codes are only ever shown in this response.
+func (handler *twoFactorConfirmHandler) confirm(userId int, code string) ([]string, int, error) {
+	var recoveryCodes []string
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		user, exists := database.IDUsersMap[userId]
+		if !exists {
+			return 404, fmt.Errorf("user doesn't exist")
+		}
+		if user.TwoFactor == nil {
+			return 409, fmt.Errorf("start enrollment first")
+		}
+		if user.TwoFactor.Enabled {
+			return 409, fmt.Errorf("two factor authentication is already enabled")
+		}
+		now := time.Now().UTC()
+		step, ok := totp.Validate(user.TwoFactor.Secret, code, now, 0)
+		if !ok {
+			return 400, fmt.Errorf("code is incorrect")
+		}
+		recoveryCodes = make([]string, 0, recoveryCodeCount)
+		hashes := make([]string, 0, recoveryCodeCount)
+		for i := 0; i < recoveryCodeCount; i++ {
+			recoveryCode, err := util.CreateRandomString(5)
+			if err != nil {
+				return 500, fmt.Errorf("could not create recovery codes")
+			}
+			recoveryCodes = append(recoveryCodes, recoveryCode)
+			hashes = append(hashes, util.HashToken(recoveryCode))
+		}
+		user.TwoFactor.Enabled = true
+		user.TwoFactor.LastStep = step
+		user.TwoFactor.RecoveryCodeHashes = hashes
+		user.TwoFactor.EnabledAt = &now
+		database.PutUser(user)
+		return 200, nil
+	})
+	if err != nil {
+		return nil, statusCode, err
+	}
+	return recoveryCodes, statusCode, nil
+}
+
+func (handler *twoFactorConfirmHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	body, ok := util.GetBody(r, &struct{ Code string }{})
+	if !ok {
+		util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+		return
+	}
+	recoveryCodes, statusCode, err := handler.confirm(userId, body.Code)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, map[string][]string{"RecoveryCodes": recoveryCodes})
+}
@@ -0,0 +1,229 @@
+package user
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/routes/login"
+	"github.com/tade3910/chirpy/routes/verify"
+	"github.com/tade3910/chirpy/util"
+)
+
+type userHandler struct {
+	db          *db.Db
+	chirpPolicy db.ChirpDeletionPolicy
+	avatarDir   string
+	credentials *login.CredentialChecker
+	verifier    *verify.Verifier
+	// Emails of deleted accounts can't be moved onto until it has passed, same as signing up
+	reregisterCooldown time.Duration
+}
+
+func GetUserHandler(db *db.Db, chirpPolicy db.ChirpDeletionPolicy, avatarDir string, credentials *login.CredentialChecker, verifier *verify.Verifier, reregisterCooldown time.Duration) *userHandler {
+	return &userHandler{
+		db:                 db,
+		chirpPolicy:        chirpPolicy,
+		avatarDir:          avatarDir,
+		credentials:        credentials,
+		verifier:           verifier,
+		reregisterCooldown: reregisterCooldown,
+	}
+}
+
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,15}$`)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+)
+
+// Pointers so fields left out of the body are left unchanged.
+// Passwords are changed through /api/users/me/password instead.
+type userUpdate struct {
+	Email       *string
+	Handle      *string
+	DisplayName *string
+	Bio         *string
+}
+
+func (update *userUpdate) validate() error {
+	if update.Email != nil && !strings.Contains(*update.Email, "@") {
+		return fmt.Errorf("a valid email is required")
+	}
+	if update.Handle != nil {
+		handle := strings.ToLower(*update.Handle)
+		if handle != "" && !handlePattern.MatchString(handle) {
+			return fmt.Errorf("handle must be 3-15 letters, digits or underscores")
+		}
+		update.Handle = &handle
+	}
+	if update.DisplayName != nil && len(*update.DisplayName) > maxDisplayNameLength {
+		return fmt.Errorf("display name can be at most %d characters", maxDisplayNameLength)
+	}
+	if update.Bio != nil && len(*update.Bio) > maxBioLength {
+		return fmt.Errorf("bio can be at most %d characters", maxBioLength)
+	}
+	return nil
+}
+
+func (handler *userHandler) updateUser(userId int, update *userUpdate) (db.PlainUser, int, error) {
+	var updated db.PlainUser
+	emailChanged := false
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		user, exists := database.IDUsersMap[userId]
+		if !exists {
+			return 404, fmt.Errorf("user doesn't exist")
+		}
+		emailChanged = update.Email != nil && *update.Email != user.Email
+		if emailChanged {
+			if _, taken := database.Users[*update.Email]; taken {
+				return 409, fmt.Errorf("email %s is already in use", *update.Email)
+			}
+			if database.IsEmailCoolingDown(*update.Email, handler.reregisterCooldown, time.Now().UTC()) {
+				return 409, fmt.Errorf("this email belonged to a recently deleted account and can't be used yet")
+			}
+			database.ChangeEmail(user, *update.Email)
+		}
+		if update.Handle != nil && *update.Handle != user.Handle {
+			ownerId, taken := database.Handles[*update.Handle]
+			if taken && ownerId != userId {
+				return 409, fmt.Errorf("handle %s is already taken", *update.Handle)
+			}
+			delete(database.Handles, user.Handle)
+			if *update.Handle != "" {
+				database.Handles[*update.Handle] = userId
+			}
+			user.Handle = *update.Handle
+		}
+		if update.DisplayName != nil {
+			user.DisplayName = *update.DisplayName
+		}
+		if update.Bio != nil {
+			user.Bio = *update.Bio
+		}
+		database.PutUser(user)
+		updated = user.PlainUser
+		return 200, nil
+	})
+	if err != nil {
+		return db.PlainUser{}, statusCode, err
+	}
+	if emailChanged {
+		if _, err := handler.verifier.SendVerification(userId); err != nil {
+			fmt.Println("Problem sending verification email:", err)
+		}
+	}
+	return updated, statusCode, nil
+}
+
+func (handler *userHandler) handlePatch(w http.ResponseWriter, r *http.Request, userId int) {
+	update, ok := util.GetBody(r, &userUpdate{})
+	if !ok {
+		util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+		return
+	}
+	if err := update.validate(); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// A new email lets a password reset be sent there, so it is as sensitive as the password
+	if update.Email != nil && !apiConfig.CheckFullLogin(w, r, "changing the email") {
+		return
+	}
+	response, statusCode, err := handler.updateUser(userId, update)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, response)
+}
+
+// The caller gets their full account, everyone else only the public profile
+func (handler *userHandler) handleGet(w http.ResponseWriter, callerId int, lookup func(*db.Database) (*db.User, bool)) {
+	database, success := handler.db.GetDatabase()
+	if !success {
+		util.RespondWithError(w, http.StatusInternalServerError, "Couldn't read from database")
+		return
+	}
+	user, exists := lookup(database)
+	if !exists {
+		util.RespondWithError(w, http.StatusNotFound, "No such user exists")
+		return
+	}
+	if user.Id == callerId {
+		util.RespondWithJSON(w, 200, user.PlainUser)
+		return
+	}
+	util.RespondWithJSON(w, 200, user.ToPublic())
+}
+
+func byId(userId int) func(*db.Database) (*db.User, bool) {
+	return func(database *db.Database) (*db.User, bool) {
+		user, exists := database.IDUsersMap[userId]
+		return user, exists
+	}
+}
+
+func byHandle(handle string) func(*db.Database) (*db.User, bool) {
+	return func(database *db.Database) (*db.User, bool) {
+		userId, exists := database.Handles[strings.ToLower(handle)]
+		if !exists {
+			return nil, false
+		}
+		user, exists := database.IDUsersMap[userId]
+		return user, exists
+	}
+}
+
+// Handles /api/users/me, /api/users/{id}, /api/users/by-handle/{handle}
+// and blocking and muting other users
+func (handler *userHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	callerId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	params := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/users/"), "/")
+	switch {
+	case len(params) == 1 && params[0] == "me":
+		switch r.Method {
+		case http.MethodGet:
+			handler.handleGet(w, callerId, byId(callerId))
+		case http.MethodPatch:
+			handler.handlePatch(w, r, callerId)
+		case http.MethodDelete:
+			handler.handleDelete(w, r, callerId)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	case len(params) == 2 && params[0] == "by-handle":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler.handleGet(w, callerId, byHandle(params[1]))
+	case len(params) == 2 && params[0] == "me" && (params[1] == "blocks" || params[1] == "mutes"):
+		handler.handleListRelations(w, r, callerId, strings.TrimSuffix(params[1], "s"))
+	case len(params) == 2 && (params[1] == block || params[1] == mute):
+		handler.handleRelation(w, r, callerId, params[0], params[1])
+	case len(params) == 1:
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		userId, err := strconv.Atoi(params[0])
+		if err != nil {
+			util.RespondWithError(w, http.StatusBadRequest, "id must be an int")
+			return
+		}
+		handler.handleGet(w, callerId, byId(userId))
+	default:
+		http.NotFound(w, r)
+	}
+}
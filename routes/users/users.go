@@ -3,53 +3,65 @@ package users
 import (
 	"fmt"
 	"net/http"
-	"strconv"
+	"strings"
+	"time"
 
 	"github.com/tade3910/chirpy/db"
 	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/password"
+	"github.com/tade3910/chirpy/routes/verify"
 	"github.com/tade3910/chirpy/util"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type usersHandler struct {
-	db *db.Db
+	db                 *db.Db
+	passwordPolicy     password.Policy
+	hasher             password.Hasher
+	verifier           *verify.Verifier
+	reregisterCooldown time.Duration
 }
 
-func GetUsersHandler(db *db.Db) *usersHandler {
+// Emails of deleted accounts can only sign up again once reregisterCooldown has passed
+func GetUsersHandler(db *db.Db, passwordPolicy password.Policy, hasher password.Hasher, verifier *verify.Verifier, reregisterCooldown time.Duration) *usersHandler {
 	return &usersHandler{
-		db: db,
+		db:                 db,
+		passwordPolicy:     passwordPolicy,
+		hasher:             hasher,
+		verifier:           verifier,
+		reregisterCooldown: reregisterCooldown,
 	}
 }
 
-func (handler *usersHandler) addUser(email string, password string) (db.PlainUser, bool) {
-	database, success := handler.db.GetDatabase()
-	if !success {
-		return db.PlainUser{}, false
-	}
-	hashPassowrd, err := bcrypt.GenerateFromPassword([]byte(password), 0)
+func (handler *usersHandler) addUser(email string, password string) (db.PlainUser, int, error) {
+	// Hashed before taking the database lock since it is slow on purpose
+	hashPassowrd, err := handler.hasher.Hash(password)
+	if err != nil {
+		return db.PlainUser{}, 500, fmt.Errorf("couldn't hash password")
+	}
+	var created db.PlainUser
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		if database.IsEmailCoolingDown(email, handler.reregisterCooldown, time.Now().UTC()) {
+			return 409, fmt.Errorf("this email belonged to a recently deleted account and can't be used yet")
+		}
+		if _, exists := database.Users[email]; exists {
+			return 409, fmt.Errorf("email is already in use")
+		}
+		id := database.NewUserId()
+		nextUser := &db.User{
+			Password: hashPassowrd,
+			PlainUser: db.PlainUser{
+				Id:    id,
+				Email: email,
+			},
+		}
+		database.PutUser(nextUser)
+		created = db.PlainUser{Id: id, Email: email}
+		return 200, nil
+	})
 	if err != nil {
-		return db.PlainUser{}, false
-	}
-	id := handler.db.GetNextUserId()
-	nextUser := &db.User{
-		Password: hashPassowrd,
-		PlainUser: db.PlainUser{
-			Id:    id,
-			Email: email,
-		},
-	}
-	_, exists := database.Users[email]
-	if exists {
-		return db.PlainUser{}, false
-	}
-	database.Users[email] = nextUser
-	database.IDUsersMap[id] = nextUser
-	success = handler.db.UpdateDatabase(database, db.UserDatabase)
-	if !success {
-		fmt.Println("Problem getting database")
-		return db.PlainUser{}, false
-	}
-	return db.PlainUser{Id: id, Email: email}, true
+		return db.PlainUser{}, statusCode, err
+	}
+	return created, statusCode, nil
 }
 
 func (handler *usersHandler) handlePost(w http.ResponseWriter, r *http.Request) {
@@ -62,12 +74,20 @@ func (handler *usersHandler) handlePost(w http.ResponseWriter, r *http.Request)
 		util.RespondWithError(w, http.StatusInternalServerError, "Invalid email posted")
 		return
 	}
-	response, ok := handler.addUser(authStruct.Email, authStruct.Password)
-	if !ok {
-		util.RespondWithError(w, http.StatusInternalServerError, "Couldn't update database")
+	if err := handler.validate(authStruct); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	response, statusCode, err := handler.addUser(authStruct.Email, authStruct.Password)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
 		return
 	}
-	util.RespondWithJSON(w, 200, response)
+	// The account is usable either way, a new link can be requested later
+	if _, err := handler.verifier.SendVerification(response.Id); err != nil {
+		fmt.Println("Problem sending verification email:", err)
+	}
+	util.RespondWithJSON(w, statusCode, response)
 }
 
 type authStruct struct {
@@ -75,54 +95,75 @@ type authStruct struct {
 	Email    string
 }
 
-func (handler *usersHandler) updateUser(userId int, email string, password string) (db.PlainUser, bool) {
-	database, success := handler.db.GetDatabase()
-	if !success {
-		return db.PlainUser{}, false
-	}
-	user, exists := database.IDUsersMap[userId]
-	if !exists {
-		return db.PlainUser{}, false
+func (handler *usersHandler) validate(auth *authStruct) error {
+	if !strings.Contains(auth.Email, "@") {
+		return fmt.Errorf("a valid email is required")
 	}
-	hashPassowrd, err := bcrypt.GenerateFromPassword([]byte(password), 0)
+	return handler.passwordPolicy.Validate(auth.Password)
+}
+
+// Passwords are only changed through /api/users/me/password, which asks for the current one
+type emailUpdate struct {
+	Email    string
+	Password *string
+}
+
+func (handler *usersHandler) updateEmail(userId int, email string) (db.PlainUser, int, error) {
+	var updated db.PlainUser
+	changed := false
+	statusCode, err := handler.db.Apply(func(database *db.Database) (int, error) {
+		user, exists := database.IDUsersMap[userId]
+		if !exists {
+			return 404, fmt.Errorf("user doesn't exist")
+		}
+		if owner, taken := database.Users[email]; taken && owner.Id != userId {
+			return 409, fmt.Errorf("email %s is already in use", email)
+		}
+		changed = email != user.Email
+		if changed && database.IsEmailCoolingDown(email, handler.reregisterCooldown, time.Now().UTC()) {
+			return 409, fmt.Errorf("this email belonged to a recently deleted account and can't be used yet")
+		}
+		database.ChangeEmail(user, email)
+		database.PutUser(user)
+		updated = user.PlainUser
+		return 200, nil
+	})
 	if err != nil {
-		return db.PlainUser{}, false
-	}
-	delete(database.Users, user.Email)
-	user.Email = email
-	user.Password = hashPassowrd
-	database.IDUsersMap[userId] = user
-	database.Users[user.Email] = user
-	success = handler.db.UpdateDatabase(database, db.NoDatabase)
-	if !success {
-		fmt.Println("Problem getting database")
-		return db.PlainUser{}, false
-	}
-	return db.PlainUser{Id: userId, Email: email}, true
+		return db.PlainUser{}, statusCode, err
+	}
+	if changed {
+		if _, err := handler.verifier.SendVerification(userId); err != nil {
+			fmt.Println("Problem sending verification email:", err)
+		}
+	}
+	return updated, statusCode, nil
 }
 
 func (handler *usersHandler) handlePut(w http.ResponseWriter, r *http.Request) {
-	userIdString, ok := r.Context().Value(apiConfig.UserId).(string)
-	if !ok {
-		util.RespondWithError(w, 500, "I didn't pass in the user Id correctly")
+	userId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	authDetails, ok := util.GetBody(r, &authStruct{})
+	update, ok := util.GetBody(r, &emailUpdate{})
 	if !ok {
-		util.RespondWithError(w, 500, "Error parsing req body")
+		util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
 		return
 	}
-	userId, err := strconv.Atoi(userIdString)
-	if err != nil {
-		util.RespondWithError(w, 500, err.Error())
+	if update.Password != nil {
+		util.RespondWithError(w, http.StatusBadRequest, "change your password with POST /api/users/me/password")
 		return
 	}
-	response, ok := handler.updateUser(userId, authDetails.Email, authDetails.Password)
-	if !ok {
-		util.RespondWithError(w, 500, "Error Updating user")
+	if !strings.Contains(update.Email, "@") {
+		util.RespondWithError(w, http.StatusBadRequest, "a valid email is required")
+		return
+	}
+	response, statusCode, err := handler.updateEmail(userId, update.Email)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
 		return
 	}
-	util.RespondWithJSON(w, 200, response)
+	util.RespondWithJSON(w, statusCode, response)
 }
 
 func (handler *usersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
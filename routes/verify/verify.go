@@ -0,0 +1,142 @@
+package verify
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/tade3910/chirpy/db"
+	"github.com/tade3910/chirpy/mailer"
+	"github.com/tade3910/chirpy/middleware/apiConfig"
+	"github.com/tade3910/chirpy/util"
+)
+
+// Mails verification links and marks users verified when they follow them
+type Verifier struct {
+	db        *db.Db
+	mailer    mailer.Mailer
+	publicUrl string
+	lifetime  time.Duration
+}
+
+func GetVerifier(db *db.Db, mailer mailer.Mailer, publicUrl string, lifetime time.Duration) *Verifier {
+	return &Verifier{
+		db:        db,
+		mailer:    mailer,
+		publicUrl: publicUrl,
+		lifetime:  lifetime,
+	}
+}
+
+func (verifier *Verifier) SendVerification(userId int) (int, error) {
+	token, err := util.CreateRandomString(32)
+	if err != nil {
+		return 500, fmt.Errorf("could not create verification token")
+	}
+	var email string
+	statusCode, err := verifier.db.Apply(func(database *db.Database) (int, error) {
+		user, exists := database.IDUsersMap[userId]
+		if !exists {
+			return 404, fmt.Errorf("user doesn't exist")
+		}
+		if user.Is_verified {
+			return 409, fmt.Errorf("email is already verified")
+		}
+		database.PutEmailToken(util.HashToken(token), db.EmailToken{
+			UserId:  userId,
+			Purpose: db.VerifyEmail,
+			Expires: time.Now().UTC().Add(verifier.lifetime),
+		})
+		email = user.Email
+		return 202, nil
+	})
+	if err != nil {
+		return statusCode, err
+	}
+	link := fmt.Sprintf("%s/api/verify?token=%s", verifier.publicUrl, url.QueryEscape(token))
+	body := fmt.Sprintf("Welcome to Chirpy! Confirm your email by visiting %s\n\nThis link expires in %s.", link, verifier.lifetime)
+	if err := verifier.mailer.Send(email, "Verify your Chirpy email", body); err != nil {
+		return 502, fmt.Errorf("could not send verification email")
+	}
+	return 202, nil
+}
+
+func (verifier *Verifier) verifyEmail(token string) (int, error) {
+	return verifier.db.Apply(func(database *db.Database) (int, error) {
+		emailToken, ok := database.ConsumeEmailToken(util.HashToken(token), db.VerifyEmail)
+		if !ok {
+			return 400, fmt.Errorf("verification token is invalid or has expired")
+		}
+		user, exists := database.IDUsersMap[emailToken.UserId]
+		if !exists {
+			return 404, fmt.Errorf("user doesn't exist")
+		}
+		user.Is_verified = true
+		database.PutUser(user)
+		return 204, nil
+	})
+}
+
+type verifyHandler struct {
+	verifier *Verifier
+}
+
+func GetVerifyHandler(verifier *Verifier) *verifyHandler {
+	return &verifyHandler{
+		verifier: verifier,
+	}
+}
+
+// GET is what the mailed link hits, POST is for clients submitting the token themselves
+func (handler *verifyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var token string
+	switch r.Method {
+	case http.MethodGet:
+		token = r.URL.Query().Get("token")
+	case http.MethodPost:
+		body, ok := util.GetBody(r, &struct{ Token string }{})
+		if !ok {
+			util.RespondWithError(w, http.StatusBadRequest, "Error parsing req body")
+			return
+		}
+		token = body.Token
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	statusCode, err := handler.verifier.verifyEmail(token)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, nil)
+}
+
+type resendHandler struct {
+	verifier *Verifier
+}
+
+func GetResendHandler(verifier *Verifier) *resendHandler {
+	return &resendHandler{
+		verifier: verifier,
+	}
+}
+
+func (handler *resendHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId, err := apiConfig.GetUserId(r)
+	if err != nil {
+		util.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	statusCode, err := handler.verifier.SendVerification(userId)
+	if err != nil {
+		util.RespondWithError(w, statusCode, err.Error())
+		return
+	}
+	util.RespondWithJSON(w, statusCode, nil)
+}
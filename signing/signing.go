@@ -0,0 +1,174 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type Key struct {
+	Id     string
+	Method jwt.SigningMethod
+	// nil for keys that can only verify
+	private interface{}
+	verify  interface{}
+}
+
+// Holds the key new tokens are signed with along with every key tokens are
+// still accepted from, so keys can be rotated without logging everyone out
+type KeySet struct {
+	signing    *Key
+	keys       map[string]*Key
+	validation Validation
+}
+
+// A set that signs and verifies with a shared HS256 secret
+func GetHmacKeySet(secret string) *KeySet {
+	key := hmacKey(secret)
+	return &KeySet{
+		signing:    key,
+		keys:       map[string]*Key{key.Id: key},
+		validation: Validation{Issuer: "chirpy"},
+	}
+}
+
+// Signs with the private key at signingKeyPath. verificationKeyPaths maps key
+// ids to PEM files of keys that are still accepted, and a non empty
+// legacySecret keeps HS256 tokens from before the switch working.
+func LoadKeySet(signingKeyId string, signingKeyPath string, verificationKeyPaths map[string]string, legacySecret string) (*KeySet, error) {
+	signingKey, err := loadKey(signingKeyId, signingKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	if signingKey.private == nil {
+		return nil, fmt.Errorf("%s holds a public key, signing needs a private key", signingKeyPath)
+	}
+	set := &KeySet{
+		signing:    signingKey,
+		keys:       map[string]*Key{signingKey.Id: signingKey},
+		validation: Validation{Issuer: "chirpy"},
+	}
+	for id, path := range verificationKeyPaths {
+		key, err := loadKey(id, path)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := set.keys[key.Id]; exists {
+			return nil, fmt.Errorf("key id %q is used more than once", key.Id)
+		}
+		set.keys[key.Id] = key
+	}
+	if legacySecret != "" {
+		key := hmacKey(legacySecret)
+		set.keys[key.Id] = key
+	}
+	return set, nil
+}
+
+func hmacKey(secret string) *Key {
+	return &Key{
+		Id:      "hs256",
+		Method:  jwt.SigningMethodHS256,
+		private: []byte(secret),
+		verify:  []byte(secret),
+	}
+}
+
+// Reads an RSA or Ed25519 key, private or public, from a PEM file. Without
+// an id one is derived from the public key.
+func loadKey(id string, path string) (*Key, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+	var private interface{}
+	var public interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		public, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s holds an unsupported %s", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	if signer, ok := private.(crypto.Signer); ok {
+		public = signer.Public()
+	}
+	key := &Key{
+		Id:      id,
+		private: private,
+		verify:  public,
+	}
+	switch public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("%s must hold an RSA or Ed25519 key", path)
+	}
+	if key.Id == "" {
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(der)
+		key.Id = hex.EncodeToString(sum[:8])
+	}
+	return key, nil
+}
+
+func (set *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(set.signing.Method, claims)
+	token.Header["kid"] = set.signing.Id
+	return token.SignedString(set.signing.private)
+}
+
+// Finds the key a token claims to be signed with for jwt.ParseWithClaims.
+// The algorithm has to be the key's own so a public key can't be passed off
+// as an HMAC secret.
+func (set *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	id, _ := token.Header["kid"].(string)
+	if id == "" {
+		// Tokens from before keys had ids were signed with the shared secret
+		id = hmacKey("").Id
+	}
+	key, exists := set.keys[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %q", errUnknownKey, id)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("%w: key %q is not used with %s", errBadAlgorithm, id, token.Method.Alg())
+	}
+	return key.verify, nil
+}
+
+// The public keys others can verify tokens with. Shared secrets stay private.
+func (set *KeySet) PublicKeys() []*Key {
+	keys := []*Key{}
+	for _, key := range set.keys {
+		if key.Method != jwt.SigningMethodHS256 {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,142 @@
+package signing
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// What a token has to satisfy beyond a good signature
+type Validation struct {
+	// Empty allows the algorithms of the keys in the set
+	Algorithms []string
+	Issuer     string
+	// Empty skips the audience check and leaves aud out of new tokens
+	Audience string
+	// Clock skew allowed on exp, nbf and iat
+	Leeway time.Duration
+	// Tokens issued longer ago than this are refused whatever their exp, 0 for no limit
+	MaxAge time.Duration
+}
+
+// Sent back in the 401 body so clients can tell a token that needs refreshing
+// from one that will never work
+type ErrorCode string
+
+const (
+	TokenMissing      ErrorCode = "token_missing"
+	TokenMalformed    ErrorCode = "token_malformed"
+	TokenBadAlgorithm ErrorCode = "token_bad_algorithm"
+	TokenUnknownKey   ErrorCode = "token_unknown_key"
+	TokenBadSignature ErrorCode = "token_bad_signature"
+	TokenExpired      ErrorCode = "token_expired"
+	TokenNotYetValid  ErrorCode = "token_not_yet_valid"
+	TokenTooOld       ErrorCode = "token_too_old"
+	TokenWrongIssuer  ErrorCode = "token_wrong_issuer"
+	TokenWrongAud     ErrorCode = "token_wrong_audience"
+	TokenRevoked      ErrorCode = "token_revoked"
+	TokenInvalid      ErrorCode = "token_invalid"
+)
+
+type ValidationError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (err *ValidationError) Error() string {
+	return err.Message
+}
+
+var (
+	errBadAlgorithm = errors.New("signing algorithm is not allowed")
+	errUnknownKey   = errors.New("token was signed with an unknown key")
+)
+
+// Fails when the allowed algorithms would reject the set's own tokens
+func (set *KeySet) SetValidation(validation Validation) error {
+	if len(validation.Algorithms) > 0 && !slices.Contains(validation.Algorithms, set.signing.Method.Alg()) {
+		return fmt.Errorf("tokens are signed with %s which is not an allowed algorithm", set.signing.Method.Alg())
+	}
+	set.validation = validation
+	return nil
+}
+
+func (set *KeySet) Issuer() string {
+	return set.validation.Issuer
+}
+
+func (set *KeySet) Audience() string {
+	return set.validation.Audience
+}
+
+func (set *KeySet) allowsAlgorithm(alg string) bool {
+	if len(set.validation.Algorithms) > 0 {
+		return slices.Contains(set.validation.Algorithms, alg)
+	}
+	for _, key := range set.keys {
+		if key.Method.Alg() == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// Verifies the token's signature and claims, filling in claims
+func (set *KeySet) Parse(tokenString string, claims jwt.Claims) *ValidationError {
+	validation := set.validation
+	options := []jwt.ParserOption{
+		jwt.WithLeeway(validation.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(validation.Issuer),
+	}
+	if validation.Audience != "" {
+		options = append(options, jwt.WithAudience(validation.Audience))
+	}
+	keyfunc := func(token *jwt.Token) (interface{}, error) {
+		if !set.allowsAlgorithm(token.Method.Alg()) {
+			return nil, errBadAlgorithm
+		}
+		return set.Keyfunc(token)
+	}
+	_, err := jwt.ParseWithClaims(tokenString, claims, keyfunc, options...)
+	if err != nil {
+		return toValidationError(err)
+	}
+	if validation.MaxAge > 0 {
+		issuedAt, err := claims.GetIssuedAt()
+		if err != nil || issuedAt == nil {
+			return &ValidationError{TokenInvalid, "token has no issued at time"}
+		}
+		if time.Since(issuedAt.Time) > validation.MaxAge+validation.Leeway {
+			return &ValidationError{TokenTooOld, fmt.Sprintf("token was issued more than %s ago", validation.MaxAge)}
+		}
+	}
+	return nil
+}
+
+func toValidationError(err error) *ValidationError {
+	switch {
+	case errors.Is(err, errBadAlgorithm):
+		return &ValidationError{TokenBadAlgorithm, errBadAlgorithm.Error()}
+	case errors.Is(err, errUnknownKey):
+		return &ValidationError{TokenUnknownKey, errUnknownKey.Error()}
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return &ValidationError{TokenMalformed, "token is malformed"}
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return &ValidationError{TokenBadSignature, "token signature is invalid"}
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return &ValidationError{TokenExpired, "token has expired"}
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return &ValidationError{TokenNotYetValid, "token is not valid yet"}
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return &ValidationError{TokenWrongIssuer, "token has the wrong issuer"}
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return &ValidationError{TokenWrongAud, "token has the wrong audience"}
+	default:
+		return &ValidationError{TokenInvalid, "token is invalid"}
+	}
+}
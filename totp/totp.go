@@ -0,0 +1,90 @@
+// Time-based one-time passwords as described in RFC 6238, with the defaults
+// authenticator apps expect: HMAC-SHA1, 6 digits and a 30 second step
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30 * time.Second
+	// Codes from this many steps either side of now are accepted to allow for clock drift
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// A random 160 bit secret, base32 encoded the way otpauth URIs carry it
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// The URI authenticator apps read from a QR code
+func Uri(issuer string, account string, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Period.Seconds())))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+func step(at time.Time) int64 {
+	return at.Unix() / int64(Period.Seconds())
+}
+
+// The code for a time step, RFC 4226 section 5.3
+func code(key []byte, counter int64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < Digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, truncated%modulo)
+}
+
+// Checks the code against the steps around now. Returns the matching step so
+// callers can refuse a code that was already used; steps at or before
+// lastStep never match.
+func Validate(secret string, candidate string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+	candidate = strings.ReplaceAll(candidate, " ", "")
+	if len(candidate) != Digits {
+		return 0, false
+	}
+	current := step(now)
+	for counter := current - Skew; counter <= current+Skew; counter++ {
+		if counter <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(code(key, counter)), []byte(candidate)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
@@ -2,15 +2,18 @@ package util
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/tade3910/chirpy/signing"
 )
 
 func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) error {
@@ -29,6 +32,11 @@ func RespondWithError(w http.ResponseWriter, code int, msg string) error {
 	return RespondWithJSON(w, code, map[string]string{"error": msg})
 }
 
+// Like RespondWithError with a machine readable code alongside the message
+func RespondWithErrorCode(w http.ResponseWriter, code int, errorCode string, msg string) error {
+	return RespondWithJSON(w, code, map[string]string{"error": msg, "code": errorCode})
+}
+
 func GetBody[T interface{}](r *http.Request, bodyStruct T) (T, bool) {
 	body, err := io.ReadAll(r.Body)
 	r.Body.Close()
@@ -61,8 +69,9 @@ func GetAuthToken(r *http.Request, auauthType authType) (string, error) {
 	return split[1], nil
 }
 
-func CreateRefreshToken() (string, error) {
-	b := make([]byte, 32)
+// Returns n random bytes hex encoded
+func CreateRandomString(n int) (string, error) {
+	b := make([]byte, n)
 	_, err := rand.Read(b)
 	if err != nil {
 		return "", err
@@ -70,16 +79,57 @@ func CreateRefreshToken() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-func CreateAcessToken(expiry_time time.Duration, user_id int, jwtSecret string) (string, error) {
+// Hex encoded SHA-256 of a token, for storing tokens without storing credentials
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func CreateRefreshToken() (string, error) {
+	return CreateRandomString(32)
+}
+
+// Registered claims plus the user's role so clients know what they can do
+type Claims struct {
+	Role string `json:"role,omitempty"`
+	// The session the token was issued for, revoking it revokes the token
+	SessionId string `json:"sid,omitempty"`
+	// Space separated scopes of a restricted token, left out for a full login
+	Scope string `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
 
+func CreateAcessToken(expiry_time time.Duration, user_id int, role string, sessionId string, scope string, keys *signing.KeySet) (string, error) {
+	// Lets the token be revoked on its own
+	id, err := CreateRandomString(16)
+	if err != nil {
+		return "", err
+	}
 	// Create claims with multiple fields populated
-	claims := jwt.RegisteredClaims{
-		// A usual scenario is to set the expiration time relative to the current time
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry_time)),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		Issuer:    "chirpy",
-		Subject:   fmt.Sprintf("%d", user_id),
+	claims := Claims{
+		Role:      role,
+		SessionId: sessionId,
+		Scope:     scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			// A usual scenario is to set the expiration time relative to the current time
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry_time)),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			Issuer:    keys.Issuer(),
+			Subject:   fmt.Sprintf("%d", user_id),
+			ID:        id,
+		},
+	}
+	if audience := keys.Audience(); audience != "" {
+		claims.Audience = jwt.ClaimStrings{audience}
+	}
+	return keys.Sign(claims)
+}
+
+// The address the request came from, without the port
+func GetClientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(jwtSecret))
+	return host
 }